@@ -1,66 +1,70 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/viper"
+	"github.com/vm-affekt/tgytbot/internal/access"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/config"
 	"github.com/vm-affekt/tgytbot/internal/dialogs"
+	"github.com/vm-affekt/tgytbot/internal/diskcache"
 	"github.com/vm-affekt/tgytbot/internal/downloader"
+	"github.com/vm-affekt/tgytbot/internal/health"
 	"github.com/vm-affekt/tgytbot/internal/logging"
+	"github.com/vm-affekt/tgytbot/internal/mediacache"
+	"github.com/vm-affekt/tgytbot/internal/metrics"
+	"github.com/vm-affekt/tgytbot/internal/queue"
+	"github.com/vm-affekt/tgytbot/internal/quota"
+	"github.com/vm-affekt/tgytbot/internal/scheduler"
+	"github.com/vm-affekt/tgytbot/internal/storage/bolt"
+	"github.com/vm-affekt/tgytbot/internal/storage/memory"
 	"github.com/vm-affekt/tgytbot/internal/telegram"
 	"go.uber.org/zap"
 )
 
+const cmdCheckConfig = "check-config"
+
 const (
-	modeEnvProduction = "prod"
-	modeEnvDebug      = "debug"
+	// ffmpegProbeTimeout limits the time of "ffmpeg -version" on startup.
+	ffmpegProbeTimeout = 10 * time.Second
+	// monitoringShutdownTimeout is the time given to running requests of the monitoring server on shutdown.
+	monitoringShutdownTimeout = 5 * time.Second
 )
 
 func main() {
-	var (
-		debugMode bool
-
-		logCfg zap.Config
-	)
-
-	viper.AddConfigPath("/etc/tgytbot")
-	viper.AddConfigPath("./configs")
-	viper.AddConfigPath(".")
-
-	viper.SetEnvPrefix("TGYTBOT")
-	viper.SetConfigName("config")
-	viper.SetConfigType("env")
-
-	viper.AutomaticEnv()
-
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			fmt.Printf("%v. Environment variables will be used as config.\n", err)
-		} else {
-			panic(fmt.Errorf("failed to read config (used file: %q): %w", viper.ConfigFileUsed(), err))
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case cmdCheckConfig:
+			os.Exit(checkConfig())
+		default:
+			fmt.Printf("Unknown command %q.\nUsage:\n  tgytbot                 run the bot\n  tgytbot %s    validate the config and check that ffmpeg works\n", os.Args[1], cmdCheckConfig)
+			os.Exit(2)
 		}
 	}
 
-	modeEnv := viper.GetString("MODE")
-	logFilePath := viper.GetString("LOG_FILE_PATH")
-
-	switch modeEnv {
-	case modeEnvProduction:
-		logCfg = zap.NewProductionConfig()
+	readConfigFile()
+	cfg, err := config.Load(viper.GetViper())
+	if err != nil {
+		fmt.Printf("ERROR! %v\n", err)
+		os.Exit(1)
+	}
 
-	case modeEnvDebug, "":
-		modeEnv = modeEnvDebug
-		debugMode = true
+	var logCfg zap.Config
+	if cfg.Debug() {
 		logCfg = zap.NewDevelopmentConfig()
-	default:
-		fmt.Printf("ERROR! Unknown debug mode specified in MODE env var: '%s'. You can use only 'prod', 'debug' or leave this variable empty. Empty MODE will be treated as 'debug'!\n", modeEnv)
-		os.Exit(1)
+	} else {
+		logCfg = zap.NewProductionConfig()
 	}
-	if logFilePath != "" {
-		logCfg.OutputPaths = append(logCfg.OutputPaths, logFilePath)
+	if cfg.LogFilePath != "" {
+		logCfg.OutputPaths = append(logCfg.OutputPaths, cfg.LogFilePath)
 	} else {
 		fmt.Println("[WARN] No LOG_FILE_PATH specified! Using 'stderr' only.")
 	}
@@ -71,42 +75,197 @@ func main() {
 	logging.SetLogger(logger)
 	log := logger.Sugar()
 
-	log.Infof("[TELEGRAM YOUTUBE DOWNLOADER BOT] Application is running. Environment mode=%q", modeEnv)
+	log.Infof("[TELEGRAM YOUTUBE DOWNLOADER BOT] Application is running. Environment mode=%q", cfg.Mode)
 	defer log.Sync()
 
 	log.Infof("Used config file path: %v", viper.ConfigFileUsed())
+	for _, warning := range cfg.Warnings {
+		log.Warn(warning)
+	}
 
-	tgApiKey := viper.GetString("TELEGRAM_API_KEY")
-	if tgApiKey == "" {
-		log.Fatal("TELEGRAM_API_KEY can't be empty!")
+	probeCtx, cancelProbe := context.WithTimeout(context.Background(), ffmpegProbeTimeout)
+	ffmpegVersion, err := downloader.FFmpegVersion(probeCtx)
+	cancelProbe()
+	if err != nil {
+		log.Fatalf("ffmpeg doesn't work: %v", err)
 	}
+	log.Infof("Found %s", ffmpegVersion)
 
-	downloadTimeout := viper.GetDuration("DOWNLOAD_TIMEOUT")
-	if downloadTimeout == 0 {
-		log.Warn("DOWNLOAD_TIMEOUT is zero!")
+	var downloadService app.DownloadService = downloader.New(cfg.Debug(), cfg.AudioProfile, cfg.Stream)
+	if cfg.TranscodeCacheDir != "" {
+		downloadService, err = diskcache.New(downloadService, cfg.TranscodeCacheDir, cfg.TranscodeCacheMaxSizeMB*1024*1024)
+		if err != nil {
+			log.Fatalf("Failed to open transcode cache: %v", err)
+		}
 	}
 
-	audioMaxFileSizeMB := viper.GetInt64("AUDIO_FILE_MAX_SIZE_MB")
-	if audioMaxFileSizeMB == 0 {
-		log.Warn("AUDIO_FILE_MAX_SIZE_MB is zero!")
+	downloadQueue, err := queue.NewFileQueue(cfg.QueueFilePath)
+	if err != nil {
+		log.Fatalf("Failed to load download queue: %v", err)
+	}
+
+	jobScheduler := scheduler.New(cfg.MaxConcurrentJobs)
+
+	var storage app.Storage
+	switch cfg.StorageDriver {
+	case config.StorageDriverBolt:
+		storage, err = bolt.Open(cfg.StoragePath)
+		if err != nil {
+			log.Fatalf("Failed to open storage: %v", err)
+		}
+	case config.StorageDriverMemory:
+		storage = memory.New()
+	}
+
+	mediaCache, err := mediacache.New(context.Background(), storage, cfg.MediaCacheMaxEntries, cfg.MediaCacheMaxAge)
+	if err != nil {
+		log.Fatalf("Failed to load media cache: %v", err)
+	}
+
+	accessControl, err := access.New(context.Background(), storage, cfg.Access)
+	if err != nil {
+		log.Fatalf("Failed to load access rules: %v", err)
+	}
+
+	quotaTracker, err := quota.New(context.Background(), storage, cfg.Quota, cfg.RateLimitRequests, cfg.RateLimitWindow)
+	if err != nil {
+		log.Fatalf("Failed to load quotas: %v", err)
 	}
 
-	downloadService := downloader.New(debugMode)
+	container := dialogs.NewContainer(downloadService, downloadQueue, jobScheduler, mediaCache, storage, quotaTracker, cfg.DownloadTimeout, cfg.AudioMaxFileSizeMB)
 
-	container := dialogs.NewContainer(downloadService, downloadTimeout, audioMaxFileSizeMB)
+	msgProc := telegram.NewMsgProcessor(cfg.TelegramAPIKey, cfg.Debug(), container, accessControl)
 
-	msgProc := telegram.NewMsgProcessor(viper.GetString("TELEGRAM_API_KEY"), debugMode, container)
-	if err := msgProc.StartLongPolling(viper.GetInt32("TELEGRAM_LONG_POLLING_TIMEOUT")); err != nil {
-		log.Fatalf("Failed to start long polling listener: %v", err)
+	metrics.RegisterQueueDepth(queueDepth(downloadQueue))
+	var monitoringServer *http.Server
+	if cfg.MonitoringListenAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/healthz", health.Handler(
+			health.Check{Name: "dispatcher", Func: msgProc.Alive},
+		))
+		mux.Handle("/readyz", health.Handler(
+			health.Check{Name: "telegram", Func: msgProc.Connected},
+			health.Check{Name: "update_loop", Func: msgProc.UpdateLoopAlive},
+			health.Check{Name: "ffmpeg", Func: checkFFmpeg},
+			health.Check{Name: "storage", Func: storage.Ping},
+		))
+		monitoringServer = &http.Server{Addr: cfg.MonitoringListenAddr, Handler: mux}
+		go func() {
+			if err := monitoringServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Failed to serve monitoring endpoints: %v", err)
+			}
+		}()
+		log.Infof("Monitoring endpoints /metrics, /healthz and /readyz are served on %s", cfg.MonitoringListenAddr)
 	}
 
-	log.Info("Long polling started. Bot is ready!")
+	switch cfg.TelegramMode {
+	case config.TelegramModePolling:
+		if err := msgProc.StartLongPolling(int32(cfg.LongPollingTimeout)); err != nil {
+			log.Fatalf("Failed to start long polling listener: %v", err)
+		}
+		log.Info("Long polling started. Bot is ready!")
+	case config.TelegramModeWebhook:
+		if err := msgProc.StartWebhook(cfg.Webhook); err != nil {
+			log.Fatalf("Failed to start webhook listener: %v", err)
+		}
+		log.Info("Webhook started. Bot is ready!")
+	}
 
 	sigInt := make(chan os.Signal, 1)
 	signal.Notify(sigInt, os.Interrupt, syscall.SIGTERM)
 	shutSig := <-sigInt
 	log.Infof("Signal received: %v. Shutdown server...", shutSig)
-	// telegramSrv.Shutdown() TODO: SHUTDOWN
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	if err := msgProc.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("Failed to shutdown gracefully: %v", err)
+	}
+	cancelShutdown()
+	if monitoringServer != nil {
+		// The drain may take the whole shutdownCtx, so the monitoring server gets its own timeout.
+		monitoringCtx, cancelMonitoring := context.WithTimeout(context.Background(), monitoringShutdownTimeout)
+		if err := monitoringServer.Shutdown(monitoringCtx); err != nil {
+			log.Errorf("Failed to shutdown monitoring server: %v", err)
+		}
+		cancelMonitoring()
+	}
+	if err := storage.Close(); err != nil {
+		log.Errorf("Failed to close storage: %v", err)
+	}
 	log.Info("Shutdown work is over. Bye :-)")
 
 }
+
+// readConfigFile reads the config file to viper. Environment variables are used as config if there is no file.
+func readConfigFile() {
+	viper.AddConfigPath("/etc/tgytbot")
+	viper.AddConfigPath("./configs")
+	viper.AddConfigPath(".")
+
+	viper.SetEnvPrefix("TGYTBOT")
+	viper.SetConfigName("config")
+	viper.SetConfigType("env")
+
+	viper.AutomaticEnv()
+
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			fmt.Printf("%v. Environment variables will be used as config.\n", err)
+		} else {
+			panic(fmt.Errorf("failed to read config (used file: %q): %w", viper.ConfigFileUsed(), err))
+		}
+	}
+}
+
+// checkConfig validates the config and checks that ffmpeg works. It prints the report and returns the exit code.
+func checkConfig() int {
+	readConfigFile()
+	fmt.Printf("Config file: %q\n", viper.ConfigFileUsed())
+	code := 0
+	cfg, err := config.Load(viper.GetViper())
+	if err != nil {
+		fmt.Printf("[FAIL] %v\n", err)
+		code = 1
+	} else {
+		fmt.Println("[OK] Config is valid")
+	}
+	for _, warning := range cfg.Warnings {
+		fmt.Printf("[WARN] %s\n", warning)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegProbeTimeout)
+	defer cancel()
+	version, err := downloader.FFmpegVersion(ctx)
+	if err != nil {
+		fmt.Printf("[FAIL] ffmpeg doesn't work: %v\n", err)
+		code = 1
+	} else {
+		fmt.Printf("[OK] %s\n", version)
+	}
+	return code
+}
+
+func checkFFmpeg(ctx context.Context) error {
+	_, err := downloader.FFmpegVersion(ctx)
+	return err
+}
+
+// queueDepth returns the function counting downloads in queues of all users.
+func queueDepth(q app.DownloadQueue) func() float64 {
+	return func() float64 {
+		ctx := context.Background()
+		userIDs, err := q.UserIDs(ctx)
+		if err != nil {
+			return 0
+		}
+		var depth int
+		for _, id := range userIDs {
+			items, err := q.List(ctx, id)
+			if err != nil {
+				continue
+			}
+			depth += len(items)
+		}
+		return float64(depth)
+	}
+}
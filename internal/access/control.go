@@ -0,0 +1,126 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+// Lists are ids of users and chats from config.
+type Lists struct {
+	// Admins can always use the bot and change access of other users.
+	Admins []int64
+	// AllowedUsers and AllowedChats restrict the bot to these users and chats. Anyone can use the bot if both are empty.
+	AllowedUsers []int64
+	AllowedChats []int64
+	BlockedUsers []int64
+}
+
+// Control is app.AccessControl. Rules made by admins are kept in memory, storage is used to restore them after restart.
+type Control struct {
+	storage      app.AccessStorage
+	admins       map[int64]struct{}
+	allowedUsers map[int64]struct{}
+	allowedChats map[int64]struct{}
+	blockedUsers map[int64]struct{}
+	now          func() time.Time
+
+	mu           sync.RWMutex
+	ruleByUserID map[int64]app.AccessRule
+}
+
+// New loads rules from storage.
+func New(ctx context.Context, storage app.AccessStorage, lists Lists) (*Control, error) {
+	c := &Control{
+		storage:      storage,
+		admins:       toSet(lists.Admins),
+		allowedUsers: toSet(lists.AllowedUsers),
+		allowedChats: toSet(lists.AllowedChats),
+		blockedUsers: toSet(lists.BlockedUsers),
+		now:          time.Now,
+		ruleByUserID: make(map[int64]app.AccessRule),
+	}
+	rules, err := storage.AccessRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load access rules: %w", err)
+	}
+	for _, rule := range rules {
+		c.ruleByUserID[rule.UserID] = rule
+	}
+	return c, nil
+}
+
+func (c *Control) CheckAccess(userID, chatID int64) error {
+	if c.IsAdmin(userID) {
+		return nil
+	}
+	c.mu.RLock()
+	rule, ok := c.ruleByUserID[userID]
+	c.mu.RUnlock()
+	if ok {
+		if rule.Banned {
+			return newBannedError()
+		}
+		return nil
+	}
+	if _, ok := c.blockedUsers[userID]; ok {
+		return newBannedError()
+	}
+	if len(c.allowedUsers) == 0 && len(c.allowedChats) == 0 {
+		return nil
+	}
+	if _, ok := c.allowedUsers[userID]; ok {
+		return nil
+	}
+	if _, ok := c.allowedChats[chatID]; ok {
+		return nil
+	}
+	return app.NewUserError(fmt.Sprintf("У вас нет доступа к этому боту. Чтобы получить доступ, отправьте администратору бота ваш идентификатор: <code>%d</code>.", userID))
+}
+
+func (c *Control) IsAdmin(userID int64) bool {
+	_, ok := c.admins[userID]
+	return ok
+}
+
+func (c *Control) Ban(ctx context.Context, adminID, userID int64) error {
+	if c.IsAdmin(userID) {
+		return app.NewUserError("Нельзя заблокировать администратора.")
+	}
+	return c.setRule(ctx, adminID, userID, true)
+}
+
+func (c *Control) Allow(ctx context.Context, adminID, userID int64) error {
+	return c.setRule(ctx, adminID, userID, false)
+}
+
+func (c *Control) setRule(ctx context.Context, adminID, userID int64, banned bool) error {
+	rule := app.AccessRule{
+		UserID:    userID,
+		Banned:    banned,
+		ChangedBy: adminID,
+		ChangedAt: c.now(),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.storage.SaveAccessRule(ctx, rule); err != nil {
+		return fmt.Errorf("failed to save access rule: %w", err)
+	}
+	c.ruleByUserID[userID] = rule
+	return nil
+}
+
+func newBannedError() *app.UserError {
+	return app.NewUserError("Вы заблокированы администратором бота.")
+}
+
+func toSet(ids []int64) map[int64]struct{} {
+	set := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
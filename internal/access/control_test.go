@@ -0,0 +1,138 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/storage/memory"
+)
+
+const (
+	adminID    = 1
+	allowedID  = 2
+	blockedID  = 3
+	strangerID = 4
+
+	allowedChatID = -100
+)
+
+func TestControl_CheckAccess(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		lists   Lists
+		rules   []app.AccessRule
+		userID  int64
+		chatID  int64
+		wantErr bool
+	}{
+		{
+			name:   "should_allow_anyone_without_allowlist",
+			lists:  Lists{BlockedUsers: []int64{blockedID}},
+			userID: strangerID,
+			chatID: strangerID,
+		},
+		{
+			name:    "should_deny_blocked_user",
+			lists:   Lists{BlockedUsers: []int64{blockedID}},
+			userID:  blockedID,
+			chatID:  blockedID,
+			wantErr: true,
+		},
+		{
+			name:    "should_deny_user_not_in_allowlist",
+			lists:   Lists{AllowedUsers: []int64{allowedID}},
+			userID:  strangerID,
+			chatID:  strangerID,
+			wantErr: true,
+		},
+		{
+			name:   "should_allow_user_in_allowlist",
+			lists:  Lists{AllowedUsers: []int64{allowedID}},
+			userID: allowedID,
+			chatID: allowedID,
+		},
+		{
+			name:   "should_allow_any_user_in_allowed_chat",
+			lists:  Lists{AllowedUsers: []int64{allowedID}, AllowedChats: []int64{allowedChatID}},
+			userID: strangerID,
+			chatID: allowedChatID,
+		},
+		{
+			name:   "should_allow_admin_always",
+			lists:  Lists{Admins: []int64{adminID}, AllowedUsers: []int64{allowedID}, BlockedUsers: []int64{adminID}},
+			userID: adminID,
+			chatID: adminID,
+		},
+		{
+			name:    "should_deny_banned_user_from_allowlist",
+			lists:   Lists{AllowedUsers: []int64{allowedID}},
+			rules:   []app.AccessRule{{UserID: allowedID, Banned: true}},
+			userID:  allowedID,
+			chatID:  allowedID,
+			wantErr: true,
+		},
+		{
+			name:   "should_allow_unbanned_user_from_blocklist",
+			lists:  Lists{AllowedUsers: []int64{allowedID}, BlockedUsers: []int64{blockedID}},
+			rules:  []app.AccessRule{{UserID: blockedID}},
+			userID: blockedID,
+			chatID: blockedID,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.New()
+			for _, rule := range tt.rules {
+				_ = storage.SaveAccessRule(ctx, rule)
+			}
+			c, err := New(ctx, storage, tt.lists)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			err = c.CheckAccess(tt.userID, tt.chatID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckAccess() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var usrErr *app.UserError
+			if err != nil && !errors.As(err, &usrErr) {
+				t.Errorf("CheckAccess() error = %v, want *app.UserError", err)
+			}
+		})
+	}
+}
+
+func TestControl_Ban(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	c, err := New(ctx, storage, Lists{Admins: []int64{adminID}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := c.Ban(ctx, adminID, adminID); err == nil {
+		t.Errorf("Ban() of admin error = nil, want error")
+	}
+	if err := c.Ban(ctx, adminID, strangerID); err != nil {
+		t.Fatalf("Ban() error = %v", err)
+	}
+	if err := c.CheckAccess(strangerID, strangerID); err == nil {
+		t.Errorf("CheckAccess() of banned user error = nil, want error")
+	}
+
+	// Rules are restored after restart.
+	c, err = New(ctx, storage, Lists{Admins: []int64{adminID}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := c.CheckAccess(strangerID, strangerID); err == nil {
+		t.Errorf("CheckAccess() of banned user after restart error = nil, want error")
+	}
+	if err := c.Allow(ctx, adminID, strangerID); err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	if err := c.CheckAccess(strangerID, strangerID); err != nil {
+		t.Errorf("CheckAccess() of allowed user error = %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// AccessRule is the decision about the user made by admin with /ban or /allow command.
+// Rules take precedence over the lists from config.
+type AccessRule struct {
+	UserID int64
+	// Banned is true when user is blocked, otherwise user is allowed even if isn't in the allowlist.
+	Banned    bool
+	ChangedBy int64
+	ChangedAt time.Time
+}
+
+// AccessControl decides who can use the bot.
+type AccessControl interface {
+	// CheckAccess returns *UserError if the user can't use the bot in the chat.
+	CheckAccess(userID, chatID int64) error
+	IsAdmin(userID int64) bool
+	Ban(ctx context.Context, adminID, userID int64) error
+	Allow(ctx context.Context, adminID, userID int64) error
+}
+
+// AccessStorage persists rules of AccessControl.
+type AccessStorage interface {
+	SaveAccessRule(ctx context.Context, rule AccessRule) error
+	AccessRules(ctx context.Context) ([]AccessRule, error)
+}
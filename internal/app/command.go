@@ -0,0 +1,19 @@
+package app
+
+import "context"
+
+// Command is the bot command like /help. Name is specified without leading slash.
+type Command struct {
+	Name        string
+	Description string
+}
+
+// CommandDialog is the dialog which handles its own commands. Commands of the dialog take precedence
+// over the commands available in any dialog.
+type CommandDialog interface {
+	Dialog
+	// Commands returns commands the dialog handles. They are shown in /help while user is in the dialog.
+	Commands() []Command
+	// OnCommand called when user sends one of the dialog's commands. args is the text after the command.
+	OnCommand(ctx context.Context, name, args string) error
+}
@@ -11,6 +11,15 @@ type Dialog interface {
 	OnEnter(ctx context.Context) error
 	// OnMessage called when user sends a message, being in this dialog
 	OnMessage(ctx context.Context, text string, msgID int) error
+	// OnCallback called when user presses inline button with callback data, being in this dialog.
+	// msgID is the id of message the button is attached to.
+	OnCallback(ctx context.Context, data string, msgID int) error
+}
+
+// NewInactiveButtonError returns error for pressed inline button which the dialog doesn't expect anymore,
+// e.g. the button of finished download.
+func NewInactiveButtonError() *UserError {
+	return NewUserError("Эта кнопка больше не активна.")
 }
 
 type DialogID int
@@ -18,17 +27,53 @@ type DialogID int
 const (
 	DialogMain = DialogID(iota)
 	DialogYoutubeDownload
+	DialogFormatPicker
+	DialogPlaylist
+	DialogSettings
 )
 
-var allDialogIDs = map[DialogID]struct{}{
-	DialogMain:            {},
-	DialogYoutubeDownload: {},
+// allDialogIDs contains names of dialogs used in logs and metrics.
+var allDialogIDs = map[DialogID]string{
+	DialogMain:            "main",
+	DialogYoutubeDownload: "download",
+	DialogFormatPicker:    "format_picker",
+	DialogPlaylist:        "playlist",
+	DialogSettings:        "settings",
+}
+
+func (id DialogID) String() string {
+	if name, ok := allDialogIDs[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("DialogID(%d)", int(id))
 }
 
 func (id DialogID) Validate() error {
 	_, ok := allDialogIDs[id]
 	if !ok {
-		return fmt.Errorf("%v is unknown dialog id", id)
+		return fmt.Errorf("%d is unknown dialog id", int(id))
 	}
 	return nil
 }
+
+// DownloadDialog is the dialog which can be asked to start downloading by other dialogs.
+type DownloadDialog interface {
+	Dialog
+	StartDownloading(ctx context.Context, req DownloadRequest) error
+	// StartBatchDownloading downloads items of the batch one by one. Failure of one item doesn't stop the batch.
+	StartBatchDownloading(ctx context.Context, batch DownloadBatch) error
+	// ResumeQueue starts downloading of the user's queue left after the restart of bot.
+	ResumeQueue(ctx context.Context) error
+}
+
+// DownloadBatch is the list of downloads requested at once, e.g. tracks of the playlist.
+type DownloadBatch struct {
+	Title string
+	Items []BatchItem
+}
+
+type BatchItem struct {
+	// Title is shown to user when item fails before its downloading is started.
+	Title   string
+	Request DownloadRequest
+}
@@ -2,16 +2,188 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"time"
 )
 
+type MediaKind int
+
+const (
+	MediaAudio = MediaKind(iota)
+	MediaVideo
+)
+
+func (k MediaKind) String() string {
+	if k == MediaVideo {
+		return "video"
+	}
+	return "audio"
+}
+
+// DownloadOptions specifies details of downloading.
+type DownloadOptions struct {
+	// Itag is the YouTube format number to download. Zero means that format is chosen automatically.
+	Itag int
+	// AudioProfile is the name of transcoding profile for audio. Empty means server's default profile.
+	AudioProfile string
+	// Clip is the time range to cut from the media. Zero value means the whole media.
+	Clip TimeRange
+	// SplitByChapters asks to produce one audio file per chapter of the video.
+	SplitByChapters bool
+	// Album overrides the album tag of audio, e.g. with the title of playlist. Empty means the title of video.
+	Album string
+	// Progress receives bytes of source streams as they're downloaded from YouTube. It can be nil.
+	Progress io.Writer `json:"-"`
+}
+
+// TimeRange is the fragment of media. Zero End means the end of media.
+type TimeRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+func (r TimeRange) IsZero() bool {
+	return r.Start == 0 && r.End == 0
+}
+
+// Duration returns length of the range within media of specified total duration.
+func (r TimeRange) Duration(total time.Duration) time.Duration {
+	end := r.End
+	if end == 0 || end > total {
+		end = total
+	}
+	if end < r.Start {
+		return 0
+	}
+	return end - r.Start
+}
+
+func (r TimeRange) String() string {
+	if r.End == 0 {
+		return fmt.Sprintf("%s - конец", FormatTimestamp(r.Start))
+	}
+	return fmt.Sprintf("%s - %s", FormatTimestamp(r.Start), FormatTimestamp(r.End))
+}
+
+// FormatTimestamp formats duration like "1:02:03" or "2:03".
+func FormatTimestamp(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	sec := int(d % time.Minute / time.Second)
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
+
+// DownloadRequest describes what user wants to download.
+type DownloadRequest struct {
+	Link    string
+	Kind    MediaKind
+	Options DownloadOptions
+}
+
+// MediaMeta is metadata of downloaded media. It's embedded into files and shown by Telegram.
+type MediaMeta struct {
+	Title    string
+	Artist   string
+	Album    string
+	Year     int
+	Duration time.Duration
+	// Thumbnail is JPEG image suitable for Telegram, i.e. not bigger than 320x320.
+	Thumbnail []byte
+}
+
+// Chapter is the named part of the video.
+type Chapter struct {
+	Title string
+	TimeRange
+}
+
+// MediaPart is the standalone file produced by splitting of the media.
+type MediaPart struct {
+	Num   int
+	Total int
+	Name  string
+	Meta  MediaMeta
+	Size  int64
+	// Stream reads the part. Closing of the stream releases resources of the part, so it must be closed.
+	Stream io.ReadCloser
+	// Err is not nil when splitting is failed. Other fields are empty in this case.
+	Err error
+}
+
 type DownloadResult struct {
 	ContentLen int64
 	Name       string
-	Stream     io.ReadCloser
+	Ext        string
+	Meta       MediaMeta
+	// SourceDuration is the duration of the whole video. It differs from Meta.Duration when the clip is cut.
+	SourceDuration time.Duration
+	// Chapters of the video. It's empty if the video has no chapters or the clip is requested.
+	Chapters []Chapter
+	Stream   io.ReadCloser
+}
+
+// FormatInfo describes one of available YouTube formats of the video.
+type FormatInfo struct {
+	Itag         int
+	Kind         MediaKind
+	MimeType     string
+	Codec        string
+	QualityLabel string
+	Bitrate      int
+	// EstimatedSize is the size in bytes. It is computed from bitrate and duration if YouTube doesn't provide it.
+	EstimatedSize int64
+	// WithAudio is true when video format already contains audio track.
+	WithAudio bool
+}
+
+type VideoInfo struct {
+	Title    string
+	Duration time.Duration
+	Formats  []FormatInfo
+	Chapters []Chapter
+}
+
+// PlaylistEntry is the video of the playlist.
+type PlaylistEntry struct {
+	// Link is the link to the video which can be passed to the download methods.
+	Link     string
+	Title    string
+	Author   string
+	Duration time.Duration
+}
+
+type PlaylistInfo struct {
+	Title   string
+	Author  string
+	Entries []PlaylistEntry
+}
+
+// TotalDuration returns the sum of durations of all entries.
+func (p PlaylistInfo) TotalDuration() time.Duration {
+	var total time.Duration
+	for _, e := range p.Entries {
+		total += e.Duration
+	}
+	return total
 }
 
 type DownloadService interface {
-	DownloadAudio(ctx context.Context, link string) (DownloadResult, error)
-	DownloadVideo(ctx context.Context, link string) (DownloadResult, error)
+	GetVideoInfo(ctx context.Context, link string) (VideoInfo, error)
+	GetPlaylistInfo(ctx context.Context, link string) (PlaylistInfo, error)
+	DownloadAudio(ctx context.Context, link string, opts DownloadOptions) (DownloadResult, error)
+	DownloadVideo(ctx context.Context, link string, opts DownloadOptions) (DownloadResult, error)
+	// AudioProfileName returns the name of audio profile used for the requested one. Empty name means
+	// the default profile of server.
+	AudioProfileName(requested string) string
+	// SplitByChapters reads the whole stream of downloaded audio and cuts it into one file per chapter of res.
+	// Parts are sent to the returned channel as soon as they are ready. The channel is closed after the last part.
+	SplitByChapters(ctx context.Context, stream io.Reader, res DownloadResult) <-chan MediaPart
+	// SplitAtSilence reads the whole stream of downloaded audio and cuts it into standalone files
+	// not bigger than maxPartSize bytes. Cuts are made at quiet moments when possible.
+	SplitAtSilence(ctx context.Context, stream io.Reader, res DownloadResult, maxPartSize int64) <-chan MediaPart
 }
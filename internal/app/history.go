@@ -0,0 +1,51 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// HistoryEntry is the record about successfully finished download.
+type HistoryEntry struct {
+	Title        string
+	Link         string
+	Kind         MediaKind
+	DownloadedAt time.Time
+}
+
+// DownloadHistory keeps the last downloads of each user.
+type DownloadHistory struct {
+	mu            sync.Mutex
+	limit         int
+	entriesByUser map[int64][]HistoryEntry
+}
+
+// NewDownloadHistory creates history keeping no more than limit entries per user.
+func NewDownloadHistory(limit int) *DownloadHistory {
+	return &DownloadHistory{
+		limit:         limit,
+		entriesByUser: make(map[int64][]HistoryEntry),
+	}
+}
+
+func (h *DownloadHistory) Add(userID int64, entry HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := append(h.entriesByUser[userID], entry)
+	if len(entries) > h.limit {
+		entries = entries[len(entries)-h.limit:]
+	}
+	h.entriesByUser[userID] = entries
+}
+
+// List returns downloads of the user from the newest to the oldest.
+func (h *DownloadHistory) List(userID int64) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := h.entriesByUser[userID]
+	res := make([]HistoryEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		res = append(res, entries[i])
+	}
+	return res
+}
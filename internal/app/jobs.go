@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// JobRegistry tracks downloads running in background, so they can be drained on shutdown.
+type JobRegistry struct {
+	mu     sync.Mutex
+	closed bool
+	// jobs maps ids of running jobs to their interrupt functions.
+	jobs   map[int]func()
+	nextID int
+	wg     sync.WaitGroup
+}
+
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{
+		jobs: make(map[int]func()),
+	}
+}
+
+// Register adds the running job. interrupt is called if the job isn't finished in time on shutdown.
+// done must be called when the job is finished. ok is false if the registry is already shut down.
+func (r *JobRegistry) Register(interrupt func()) (done func(), ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, false
+	}
+	id := r.nextID
+	r.nextID++
+	r.jobs[id] = interrupt
+	r.wg.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.jobs, id)
+			r.mu.Unlock()
+			r.wg.Done()
+		})
+	}, true
+}
+
+// Closed reports whether Shutdown is called. Queued jobs shouldn't be started after that.
+func (r *JobRegistry) Closed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// Shutdown prevents registering of new jobs and waits for running ones until ctx is done.
+// Then it interrupts the rest of jobs and waits for them at most interruptTimeout.
+// It returns the number of interrupted jobs.
+func (r *JobRegistry) Shutdown(ctx context.Context, interruptTimeout time.Duration) (interrupted int, err error) {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	if waitGroup(ctx, &r.wg) == nil {
+		return 0, nil
+	}
+
+	r.mu.Lock()
+	interrupted = len(r.jobs)
+	for _, interrupt := range r.jobs {
+		interrupt()
+	}
+	r.mu.Unlock()
+	interruptCtx, cancel := context.WithTimeout(context.Background(), interruptTimeout)
+	defer cancel()
+	return interrupted, waitGroup(interruptCtx, &r.wg)
+}
+
+// waitGroup waits for wg until ctx is done.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
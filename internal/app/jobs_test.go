@@ -0,0 +1,61 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobRegistry_Shutdown(t *testing.T) {
+	tests := []struct {
+		name            string
+		finishOnTime    bool
+		wantInterrupted int
+	}{
+		{
+			name:         "should_wait_for_jobs_finished_in_time",
+			finishOnTime: true,
+		},
+		{
+			name:            "should_interrupt_jobs_on_timeout",
+			wantInterrupted: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewJobRegistry()
+			interruptCh := make(chan struct{})
+			done, ok := r.Register(func() { close(interruptCh) })
+			if !ok {
+				t.Fatalf("Register() ok = false, want true")
+			}
+			go func() {
+				if tt.finishOnTime {
+					time.Sleep(10 * time.Millisecond)
+				} else {
+					<-interruptCh
+				}
+				done()
+			}()
+			timeout := 50 * time.Millisecond
+			if tt.finishOnTime {
+				timeout = time.Second
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			interrupted, err := r.Shutdown(ctx, time.Second)
+			if err != nil {
+				t.Fatalf("Shutdown() error = %v", err)
+			}
+			if interrupted != tt.wantInterrupted {
+				t.Errorf("Shutdown() interrupted = %d, want %d", interrupted, tt.wantInterrupted)
+			}
+			if !r.Closed() {
+				t.Errorf("Closed() = false after Shutdown()")
+			}
+			if _, ok := r.Register(func() {}); ok {
+				t.Errorf("Register() after Shutdown() ok = true, want false")
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CachedMedia is the media already uploaded to Telegram. It can be sent again by file_id
+// without downloading and uploading.
+type CachedMedia struct {
+	Key   string
+	Kind  MediaKind
+	Title string
+	// FileIDs are Telegram ids of uploaded files in order of sending. Media split into parts has several files.
+	FileIDs  []string
+	CachedAt time.Time
+}
+
+// MediaCacheKey returns the key of media downloaded by request. Media is the same only if all options
+// affecting the content of files are the same. partSize is the max size of file the media is split by.
+// The audio profile of request must be resolved by DownloadService.AudioProfileName.
+func MediaCacheKey(videoID string, req DownloadRequest, partSize int64) string {
+	opts := req.Options
+	return fmt.Sprintf("%d:%s:itag=%d:profile=%s:clip=%d-%d:album=%s:chapters=%t:part=%d",
+		req.Kind, videoID, opts.Itag, opts.AudioProfile, opts.Clip.Start, opts.Clip.End, opts.Album, opts.SplitByChapters, partSize)
+}
+
+// MediaCache keeps file_ids of media uploaded to Telegram.
+type MediaCache interface {
+	// Get returns the media by key. ok is false if media isn't cached or its entry is expired.
+	Get(ctx context.Context, key string) (media CachedMedia, ok bool, err error)
+	Put(ctx context.Context, media CachedMedia) error
+	// Invalidate removes the media, e.g. when Telegram doesn't accept its file_id anymore.
+	Invalidate(ctx context.Context, key string) error
+}
+
+// MediaCacheStorage persists entries of MediaCache.
+type MediaCacheStorage interface {
+	SaveCachedMedia(ctx context.Context, media CachedMedia) error
+	DeleteCachedMedia(ctx context.Context, key string) error
+	CachedMedia(ctx context.Context) ([]CachedMedia, error)
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// QueuedDownload is the download waiting in the user's queue. Exactly one of Request and Batch is set.
+type QueuedDownload struct {
+	ID       string
+	UserID   int64
+	UserName string
+	// ChatID is the chat where the download is requested. Access of user is checked in this chat
+	// when the download is continued after restart. It's zero for downloads queued by older versions.
+	ChatID int64 `json:",omitempty"`
+	// Title is shown to user in the list of queue.
+	Title   string
+	Request *DownloadRequest `json:",omitempty"`
+	Batch   *DownloadBatch   `json:",omitempty"`
+	AddedAt time.Time
+}
+
+// DownloadQueue keeps FIFO queue of downloads for every user.
+type DownloadQueue interface {
+	// Push appends the download to the end of the user's queue and returns its position starting from 1.
+	// ID of the download is generated if it's empty.
+	Push(ctx context.Context, item QueuedDownload) (position int, err error)
+	// PushFront puts the download to the beginning of the user's queue, e.g. to continue interrupted download first.
+	PushFront(ctx context.Context, item QueuedDownload) error
+	// Pop removes and returns the first download of the user's queue. ok is false if the queue is empty.
+	Pop(ctx context.Context, userID int64) (item QueuedDownload, ok bool, err error)
+	List(ctx context.Context, userID int64) ([]QueuedDownload, error)
+	// Remove removes the download from the user's queue. ok is false if there is no such download.
+	Remove(ctx context.Context, userID int64, id string) (ok bool, err error)
+	// UserIDs returns ids of users having non-empty queues.
+	UserIDs(ctx context.Context) ([]int64, error)
+}
+
+// JobScheduler limits the number of downloads running at once for all users.
+type JobScheduler interface {
+	// Acquire blocks until the job of user may run or ctx is done. If the job has to wait,
+	// onQueued is called with its position in the line. release must be called after the job is finished.
+	Acquire(ctx context.Context, userID int64, onQueued func(position int)) (release func(), err error)
+	// Position returns the position of the user's waiting job in the line starting from 1.
+	// Zero means that user has no waiting jobs.
+	Position(userID int64) int
+}
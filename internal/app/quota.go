@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrQuotaExceeded is the cause of UserError returned when user has spent the quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaLimits restricts downloads of user per day and per month. Zero limit means no limit.
+type QuotaLimits struct {
+	DailyDownloads      int
+	MonthlyDownloads    int
+	DailyMB             int64
+	MonthlyMB           int64
+	DailyAudioMinutes   int
+	MonthlyAudioMinutes int
+}
+
+// QuotaUsage is the amount of downloaded media.
+type QuotaUsage struct {
+	Downloads     int
+	Bytes         int64
+	AudioDuration time.Duration
+}
+
+// QuotaStatus describes limits of user and their usage in the current day and month.
+type QuotaStatus struct {
+	Limits QuotaLimits
+	// Overridden is true when limits are set by admin for this user.
+	Overridden bool
+	Daily      QuotaUsage
+	Monthly    QuotaUsage
+}
+
+// Quota limits the usage of bot by every user.
+type Quota interface {
+	// CheckRate registers the request of user and returns *UserError if user sends requests too often.
+	CheckRate(userID int64) error
+	// Check returns *UserError caused by ErrQuotaExceeded if any quota of user is spent.
+	Check(userID int64) error
+	// Record adds the downloaded media to the usage of user.
+	Record(ctx context.Context, userID int64, usage QuotaUsage) error
+	Status(userID int64) QuotaStatus
+	// SetLimits overrides limits of the user. nil limits resets them to default ones.
+	SetLimits(ctx context.Context, userID int64, limits *QuotaLimits) error
+}
+
+// UserQuota is the usage and overridden limits of user saved to storage.
+type UserQuota struct {
+	UserID int64
+	// Day and Month are the periods of usage like "2024-01-31" and "2024-01".
+	Day     string
+	Daily   QuotaUsage
+	Month   string
+	Monthly QuotaUsage
+	Limits  *QuotaLimits `json:",omitempty"`
+}
+
+// QuotaStorage persists usage of Quota.
+type QuotaStorage interface {
+	SaveUserQuota(ctx context.Context, quota UserQuota) error
+	UserQuotas(ctx context.Context) ([]UserQuota, error)
+}
@@ -2,15 +2,27 @@ package app
 
 import (
 	"context"
+	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"io"
+	"time"
 )
 
 type ReqUserProvider interface {
 	User() *tgbotapi.User
+	// ChatID returns the chat where the user sends messages to the bot.
+	ChatID() int64
 
 	SendMessageWithKeyboardf(ctx context.Context, replyKeyboard *tgbotapi.ReplyKeyboardMarkup, text string, args ...interface{}) (messageID int, err error)
-	SendAudio(ctx context.Context, stream io.Reader, fileName string) error
+	SendMessageWithInlineKeyboardf(ctx context.Context, inlineKeyboard *tgbotapi.InlineKeyboardMarkup, text string, args ...interface{}) (messageID int, err error)
+	// EditMessagef replaces text and inline keyboard of the message sent by bot. Nil keyboard removes the keyboard.
+	// RateLimitError is returned when Telegram asks to slow down.
+	EditMessagef(ctx context.Context, msgID int, inlineKeyboard *tgbotapi.InlineKeyboardMarkup, text string, args ...interface{}) error
+	// SendAudio uploads the audio and returns its file_id, which can be used to send the same file again.
+	SendAudio(ctx context.Context, stream io.Reader, fileName string, meta MediaMeta) (fileID string, err error)
+	SendVideo(ctx context.Context, stream io.Reader, fileName string) (fileID string, err error)
+	// SendMediaByFileID sends the file uploaded before.
+	SendMediaByFileID(ctx context.Context, kind MediaKind, fileID string) error
 
 	RedirectToDialog(ctx context.Context, id DialogID) (newDlg Dialog, err error)
 	DeleteMessages(ctx context.Context, msgIDs ...int) error
@@ -19,3 +31,13 @@ type ReqUserProvider interface {
 func SendMessagef(ctx context.Context, rup ReqUserProvider, text string, args ...interface{}) (messageID int, err error) {
 	return rup.SendMessageWithKeyboardf(ctx, nil, text, args...)
 }
+
+// RateLimitError is returned when Telegram rejects the request because of too many requests.
+type RateLimitError struct {
+	// RetryAfter is the time after which the request can be repeated.
+	RetryAfter time.Duration
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("too many requests, retry after %v", err.RetryAfter)
+}
@@ -0,0 +1,33 @@
+package app
+
+import "sync"
+
+// UserSettings are preferences of the user chosen by /settings.
+type UserSettings struct {
+	// AudioProfile is the transcoding profile used when user doesn't pick it explicitly. Empty means server's default profile.
+	AudioProfile string
+}
+
+type UserSettingsStore struct {
+	mu               sync.Mutex
+	settingsByUserID map[int64]UserSettings
+}
+
+func NewUserSettingsStore() *UserSettingsStore {
+	return &UserSettingsStore{
+		settingsByUserID: make(map[int64]UserSettings),
+	}
+}
+
+// Get returns settings of the user. Zero settings are returned for the user who has never changed them.
+func (s *UserSettingsStore) Get(userID int64) UserSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settingsByUserID[userID]
+}
+
+func (s *UserSettingsStore) Set(userID int64, settings UserSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settingsByUserID[userID] = settings
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// StoredDialog is the current dialog of user saved to storage.
+type StoredDialog struct {
+	UserID   int64
+	UserName string
+	ID       DialogID
+	// State is saved by StatefulDialog. It's empty for dialogs without state.
+	State json.RawMessage `json:",omitempty"`
+}
+
+// StatefulDialog is the dialog which state is saved to storage and restored after restart of bot.
+type StatefulDialog interface {
+	Dialog
+	MarshalState() ([]byte, error)
+	// RestoreState is called instead of OnEnter when the dialog is restored after restart.
+	RestoreState(data []byte) error
+}
+
+// DialogStorage persists current dialogs of users.
+type DialogStorage interface {
+	SaveDialog(ctx context.Context, dlg StoredDialog) error
+	Dialogs(ctx context.Context) ([]StoredDialog, error)
+}
+
+// JobStorage persists downloads which are running now. Jobs left in storage after restart
+// were interrupted by the crash of bot.
+type JobStorage interface {
+	// SaveActiveJob saves the running job of user. User can run only one job at once.
+	SaveActiveJob(ctx context.Context, job QueuedDownload) error
+	DeleteActiveJob(ctx context.Context, userID int64) error
+	ActiveJobs(ctx context.Context) ([]QueuedDownload, error)
+}
+
+// Storage keeps the state of bot between restarts.
+type Storage interface {
+	DialogStorage
+	JobStorage
+	MediaCacheStorage
+	AccessStorage
+	QuotaStorage
+	// Ping checks that storage is reachable.
+	Ping(ctx context.Context) error
+	Close() error
+}
@@ -4,12 +4,17 @@ import "sync"
 
 type UserDialogState struct {
 	mu             *sync.Mutex
-	dialogByUserID map[int64]Dialog
+	dialogByUserID map[int64]userDialog
+}
+
+type userDialog struct {
+	id     DialogID
+	dialog Dialog
 }
 
 func NewUserDialogState() *UserDialogState {
 	return &UserDialogState{
-		dialogByUserID: make(map[int64]Dialog),
+		dialogByUserID: make(map[int64]userDialog),
 		mu:             new(sync.Mutex),
 	}
 }
@@ -18,16 +23,25 @@ func (uds *UserDialogState) FindDialogByUser(userID int64) Dialog {
 	uds.mu.Lock()
 	defer uds.mu.Unlock()
 
-	dialog, ok := uds.dialogByUserID[userID]
+	ud, ok := uds.dialogByUserID[userID]
 	if !ok {
 		return nil
 	}
-	return dialog
+	return ud.dialog
+}
+
+// FindDialogIDByUser returns id of the current dialog of user. ok is false if user has no dialog.
+func (uds *UserDialogState) FindDialogIDByUser(userID int64) (id DialogID, ok bool) {
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	ud, ok := uds.dialogByUserID[userID]
+	return ud.id, ok
 }
 
-func (uds *UserDialogState) SetDialogForUser(userID int64, dialog Dialog) {
+func (uds *UserDialogState) SetDialogForUser(userID int64, id DialogID, dialog Dialog) {
 	uds.mu.Lock()
 	defer uds.mu.Unlock()
 
-	uds.dialogByUserID[userID] = dialog
+	uds.dialogByUserID[userID] = userDialog{id: id, dialog: dialog}
 }
@@ -19,6 +19,10 @@ func (err *UserError) WithCause(cause error) *UserError {
 	return err
 }
 
+func (err *UserError) Unwrap() error {
+	return err.cause
+}
+
 func (err *UserError) Error() string {
 	msg := &strings.Builder{}
 	_, _ = fmt.Fprintf(msg, "user error with message=%q", err.UserMessage)
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vm-affekt/tgytbot/internal/access"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/downloader"
+	"github.com/vm-affekt/tgytbot/internal/telegram"
+)
+
+const (
+	ModeProduction = "prod"
+	ModeDebug      = "debug"
+)
+
+const (
+	TelegramModePolling = "polling"
+	TelegramModeWebhook = "webhook"
+)
+
+const (
+	StorageDriverBolt   = "bolt"
+	StorageDriverMemory = "memory"
+)
+
+// TelegramMaxUploadSizeMB is the maximum size of file uploaded by bot to Telegram.
+const TelegramMaxUploadSizeMB = 50
+
+const (
+	defaultLongPollingTimeout = 60
+	defaultDownloadTimeout    = 5 * time.Hour
+	defaultAudioMaxFileSizeMB = 48
+
+	defaultQueueFilePath   = "data/queue.json"
+	defaultStorageFilePath = "data/tgytbot.db"
+
+	defaultMediaCacheMaxEntries = 10000
+	defaultMediaCacheMaxAge     = 30 * 24 * time.Hour
+
+	defaultTranscodeCacheMaxSizeMB = 2048
+
+	defaultRateLimitWindow = time.Minute
+
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+const oneMB = 1024 * 1024
+
+// Config is the validated config of bot. Zero values of optional settings are replaced by defaults.
+type Config struct {
+	Mode        string
+	LogFilePath string
+
+	TelegramAPIKey string
+	TelegramMode   string
+	// LongPollingTimeout is the timeout of getUpdates in seconds.
+	LongPollingTimeout int
+	Webhook            telegram.WebhookConfig
+
+	// DownloadTimeout limits the time of one download. Zero disables the limit.
+	DownloadTimeout    time.Duration
+	AudioMaxFileSizeMB int64
+	AudioProfile       downloader.AudioProfile
+	Stream             downloader.StreamOptions
+
+	// TranscodeCacheDir is the directory of transcode cache. Empty directory disables the cache.
+	TranscodeCacheDir       string
+	TranscodeCacheMaxSizeMB int64
+
+	QueueFilePath     string
+	MaxConcurrentJobs int
+
+	StorageDriver string
+	StoragePath   string
+
+	MediaCacheMaxEntries int
+	MediaCacheMaxAge     time.Duration
+
+	Access access.Lists
+
+	Quota             app.QuotaLimits
+	RateLimitRequests int
+	RateLimitWindow   time.Duration
+
+	// MonitoringListenAddr is the address of HTTP server with metrics and health checks. Empty address disables the server.
+	MonitoringListenAddr string
+	ShutdownTimeout      time.Duration
+
+	// Warnings describe settings which are valid but probably aren't intended.
+	Warnings []string
+}
+
+// Debug reports whether the bot runs in debug mode.
+func (c Config) Debug() bool {
+	return c.Mode == ModeDebug
+}
+
+// Error contains all problems found in config.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load reads the config from v and validates it. All found problems are reported at once by *Error.
+func Load(v *viper.Viper) (Config, error) {
+	r := reader{v: v}
+	var c Config
+
+	c.Mode = r.oneOf("MODE", ModeDebug, ModeProduction, ModeDebug)
+	c.LogFilePath = r.string("LOG_FILE_PATH", "")
+
+	c.TelegramAPIKey = r.string("TELEGRAM_API_KEY", "")
+	if c.TelegramAPIKey == "" {
+		r.problemf("TELEGRAM_API_KEY can't be empty")
+	}
+	c.TelegramMode = r.oneOf("TELEGRAM_MODE", TelegramModePolling, TelegramModePolling, TelegramModeWebhook)
+	c.LongPollingTimeout = r.int("TELEGRAM_LONG_POLLING_TIMEOUT", defaultLongPollingTimeout, 0, 600)
+	c.Webhook = telegram.WebhookConfig{
+		URL:              r.string("TELEGRAM_WEBHOOK_URL", ""),
+		Path:             r.string("TELEGRAM_WEBHOOK_PATH", telegram.DefaultWebhookPath),
+		ListenAddr:       r.string("TELEGRAM_WEBHOOK_LISTEN_ADDR", telegram.DefaultWebhookListenAddr),
+		SecretToken:      r.string("TELEGRAM_WEBHOOK_SECRET", ""),
+		TLSCertFile:      r.string("TELEGRAM_WEBHOOK_TLS_CERT_FILE", ""),
+		TLSKeyFile:       r.string("TELEGRAM_WEBHOOK_TLS_KEY_FILE", ""),
+		DeleteOnShutdown: r.bool("TELEGRAM_WEBHOOK_DELETE_ON_SHUTDOWN", true),
+	}
+	if c.TelegramMode == TelegramModeWebhook {
+		if err := c.Webhook.Validate(); err != nil {
+			r.problemf("TELEGRAM_WEBHOOK_*: %v", err)
+		}
+		r.listenAddr("TELEGRAM_WEBHOOK_LISTEN_ADDR", c.Webhook.ListenAddr)
+		if c.Webhook.SecretToken == "" {
+			r.warnf("TELEGRAM_WEBHOOK_SECRET is empty! Anyone knowing the webhook url can send updates to the bot.")
+		}
+	}
+
+	c.DownloadTimeout = r.duration("DOWNLOAD_TIMEOUT", defaultDownloadTimeout, 0)
+	if c.DownloadTimeout == 0 {
+		r.warnf("DOWNLOAD_TIMEOUT is zero! Downloads aren't limited in time.")
+	}
+	// Zero was allowed before and means the default size, so configs of existing deployments stay valid.
+	c.AudioMaxFileSizeMB = r.int64("AUDIO_FILE_MAX_SIZE_MB", defaultAudioMaxFileSizeMB, 0, TelegramMaxUploadSizeMB)
+	if c.AudioMaxFileSizeMB == 0 {
+		r.warnf("AUDIO_FILE_MAX_SIZE_MB is zero! Default size (%d MB) will be used.", defaultAudioMaxFileSizeMB)
+		c.AudioMaxFileSizeMB = defaultAudioMaxFileSizeMB
+	}
+	profileName := r.string("AUDIO_PROFILE", downloader.DefaultAudioProfileName)
+	profile, err := downloader.AudioProfileByName(profileName)
+	if err != nil {
+		r.problemf("AUDIO_PROFILE: %v", err)
+	}
+	c.AudioProfile = profile
+
+	defaultStream := downloader.DefaultStreamOptions()
+	c.Stream = downloader.StreamOptions{
+		ChunkSize:      defaultStream.ChunkSize,
+		Concurrency:    r.int("DOWNLOAD_CONCURRENCY", defaultStream.Concurrency, 1, 64),
+		MaxRetries:     r.int("DOWNLOAD_CHUNK_MAX_RETRIES", defaultStream.MaxRetries, 0, 100),
+		RetryBaseDelay: r.duration("DOWNLOAD_RETRY_BASE_DELAY", defaultStream.RetryBaseDelay, time.Millisecond),
+		RetryMaxDelay:  r.duration("DOWNLOAD_RETRY_MAX_DELAY", defaultStream.RetryMaxDelay, time.Millisecond),
+	}
+	if chunkSizeMB := r.int64("DOWNLOAD_CHUNK_SIZE_MB", 0, 1, 1024); chunkSizeMB > 0 {
+		c.Stream.ChunkSize = chunkSizeMB * oneMB
+	}
+	if c.Stream.RetryMaxDelay < c.Stream.RetryBaseDelay {
+		r.problemf("DOWNLOAD_RETRY_MAX_DELAY (%v) must not be less than DOWNLOAD_RETRY_BASE_DELAY (%v)", c.Stream.RetryMaxDelay, c.Stream.RetryBaseDelay)
+	}
+
+	c.TranscodeCacheDir = r.string("TRANSCODE_CACHE_DIR", "")
+	c.TranscodeCacheMaxSizeMB = r.int64("TRANSCODE_CACHE_MAX_SIZE_MB", defaultTranscodeCacheMaxSizeMB, 1, 1<<30)
+
+	c.QueueFilePath = r.string("QUEUE_FILE_PATH", defaultQueueFilePath)
+	if r.string("MAX_CONCURRENT_JOBS", "") == "" {
+		r.warnf("MAX_CONCURRENT_JOBS is not specified! Number of CPUs (%d) will be used.", runtime.NumCPU())
+	}
+	c.MaxConcurrentJobs = r.int("MAX_CONCURRENT_JOBS", runtime.NumCPU(), 1, 1024)
+
+	c.StorageDriver = r.oneOf("STORAGE_DRIVER", StorageDriverBolt, StorageDriverBolt, StorageDriverMemory)
+	c.StoragePath = r.string("STORAGE_PATH", defaultStorageFilePath)
+	if c.StorageDriver == StorageDriverMemory {
+		r.warnf("Memory storage is used! Dialogs of users will be lost on restart.")
+	}
+
+	c.MediaCacheMaxEntries = r.int("MEDIA_CACHE_MAX_ENTRIES", defaultMediaCacheMaxEntries, 1, 1<<30)
+	c.MediaCacheMaxAge = r.duration("MEDIA_CACHE_MAX_AGE", defaultMediaCacheMaxAge, time.Minute)
+
+	c.Access = access.Lists{
+		Admins:       r.ids("ADMIN_USER_IDS"),
+		AllowedUsers: r.ids("ALLOWED_USER_IDS"),
+		AllowedChats: r.ids("ALLOWED_CHAT_IDS"),
+		BlockedUsers: r.ids("BLOCKED_USER_IDS"),
+	}
+	if len(c.Access.AllowedUsers) == 0 && len(c.Access.AllowedChats) == 0 {
+		r.warnf("ALLOWED_USER_IDS and ALLOWED_CHAT_IDS are empty! Anyone can use the bot.")
+	}
+
+	const maxLimit = 1 << 30
+	c.Quota = app.QuotaLimits{
+		DailyDownloads:      r.int("QUOTA_DAILY_DOWNLOADS", 0, 0, maxLimit),
+		MonthlyDownloads:    r.int("QUOTA_MONTHLY_DOWNLOADS", 0, 0, maxLimit),
+		DailyMB:             r.int64("QUOTA_DAILY_MB", 0, 0, maxLimit),
+		MonthlyMB:           r.int64("QUOTA_MONTHLY_MB", 0, 0, maxLimit),
+		DailyAudioMinutes:   r.int("QUOTA_DAILY_AUDIO_MINUTES", 0, 0, maxLimit),
+		MonthlyAudioMinutes: r.int("QUOTA_MONTHLY_AUDIO_MINUTES", 0, 0, maxLimit),
+	}
+	c.RateLimitRequests = r.int("RATE_LIMIT_REQUESTS", 0, 0, maxLimit)
+	c.RateLimitWindow = r.duration("RATE_LIMIT_WINDOW", defaultRateLimitWindow, time.Second)
+
+	c.MonitoringListenAddr = r.string("MONITORING_LISTEN_ADDR", "")
+	if c.MonitoringListenAddr != "" {
+		r.listenAddr("MONITORING_LISTEN_ADDR", c.MonitoringListenAddr)
+	}
+	c.ShutdownTimeout = r.duration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout, time.Second)
+
+	c.Warnings = r.warnings
+	if len(r.problems) > 0 {
+		return c, &Error{Problems: r.problems}
+	}
+	return c, nil
+}
+
+// reader reads values of config keys. Empty value means that the key isn't set and the default is used.
+// Invalid values are collected to problems instead of stopping at the first one.
+type reader struct {
+	v        *viper.Viper
+	problems []string
+	warnings []string
+}
+
+func (r *reader) problemf(format string, args ...interface{}) {
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+func (r *reader) warnf(format string, args ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+func (r *reader) string(key, def string) string {
+	if s := strings.TrimSpace(r.v.GetString(key)); s != "" {
+		return s
+	}
+	return def
+}
+
+func (r *reader) oneOf(key, def string, allowed ...string) string {
+	s := r.string(key, def)
+	for _, a := range allowed {
+		if s == a {
+			return s
+		}
+	}
+	r.problemf("%s: unknown value %q, allowed values are %s", key, s, strings.Join(allowed, ", "))
+	return def
+}
+
+func (r *reader) int(key string, def, min, max int) int {
+	return int(r.int64(key, int64(def), int64(min), int64(max)))
+}
+
+func (r *reader) int64(key string, def, min, max int64) int64 {
+	s := r.string(key, "")
+	if s == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		r.problemf("%s: %q is not an integer", key, s)
+		return def
+	}
+	if n < min || n > max {
+		r.problemf("%s: %d is out of range [%d, %d]", key, n, min, max)
+		return def
+	}
+	return n
+}
+
+func (r *reader) duration(key string, def, min time.Duration) time.Duration {
+	s := r.string(key, "")
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		r.problemf("%s: %q is not a duration like 30s, 5m or 1h", key, s)
+		return def
+	}
+	if d < min {
+		r.problemf("%s: %v is less than %v", key, d, min)
+		return def
+	}
+	return d
+}
+
+func (r *reader) bool(key string, def bool) bool {
+	s := r.string(key, "")
+	if s == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		r.problemf("%s: %q is not a boolean", key, s)
+		return def
+	}
+	return b
+}
+
+// ids parses comma separated ids of users or chats.
+func (r *reader) ids(key string) []int64 {
+	var ids []int64
+	for _, field := range strings.Split(r.string(key, ""), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			r.problemf("%s: %q is not an id", key, field)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (r *reader) listenAddr(key, addr string) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		r.problemf("%s: %q is not an address like :9090 or 127.0.0.1:9090", key, addr)
+	}
+}
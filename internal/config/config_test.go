@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vm-affekt/tgytbot/internal/downloader"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       map[string]string
+		check        func(t *testing.T, c Config)
+		wantProblems []string
+	}{
+		{
+			name:   "should_apply_defaults",
+			values: map[string]string{"TELEGRAM_API_KEY": "key"},
+			check: func(t *testing.T, c Config) {
+				if c.Mode != ModeDebug || c.TelegramMode != TelegramModePolling || c.StorageDriver != StorageDriverBolt {
+					t.Errorf("modes = %q, %q, %q, want defaults", c.Mode, c.TelegramMode, c.StorageDriver)
+				}
+				if c.AudioMaxFileSizeMB != defaultAudioMaxFileSizeMB || c.DownloadTimeout != defaultDownloadTimeout {
+					t.Errorf("AudioMaxFileSizeMB = %d, DownloadTimeout = %v, want defaults", c.AudioMaxFileSizeMB, c.DownloadTimeout)
+				}
+				if c.AudioProfile.Name != downloader.DefaultAudioProfileName {
+					t.Errorf("AudioProfile = %q, want %q", c.AudioProfile.Name, downloader.DefaultAudioProfileName)
+				}
+				if !reflect.DeepEqual(c.Stream, downloader.DefaultStreamOptions()) {
+					t.Errorf("Stream = %+v, want default options", c.Stream)
+				}
+			},
+		},
+		{
+			name: "should_read_values",
+			values: map[string]string{
+				"TELEGRAM_API_KEY":           "key",
+				"MODE":                       "prod",
+				"DOWNLOAD_TIMEOUT":           "0",
+				"AUDIO_FILE_MAX_SIZE_MB":     "50",
+				"DOWNLOAD_CHUNK_SIZE_MB":     "2",
+				"DOWNLOAD_CHUNK_MAX_RETRIES": "0",
+				"ALLOWED_USER_IDS":           "1, 2",
+				"MONITORING_LISTEN_ADDR":     ":9090",
+			},
+			check: func(t *testing.T, c Config) {
+				if c.Debug() {
+					t.Errorf("Debug() = true, want false")
+				}
+				if c.DownloadTimeout != 0 || c.AudioMaxFileSizeMB != 50 || c.Stream.ChunkSize != 2*oneMB || c.Stream.MaxRetries != 0 {
+					t.Errorf("config = %+v, want values from keys", c)
+				}
+				if !reflect.DeepEqual(c.Access.AllowedUsers, []int64{1, 2}) {
+					t.Errorf("AllowedUsers = %v, want [1 2]", c.Access.AllowedUsers)
+				}
+			},
+		},
+		{
+			name: "should_report_all_problems",
+			values: map[string]string{
+				"MODE":                     "test",
+				"AUDIO_FILE_MAX_SIZE_MB":   "-1",
+				"DOWNLOAD_TIMEOUT":         "5 hours",
+				"DOWNLOAD_RETRY_MAX_DELAY": "100ms",
+				"ADMIN_USER_IDS":           "1,admin",
+				"MONITORING_LISTEN_ADDR":   "9090",
+			},
+			wantProblems: []string{
+				`MODE: unknown value "test", allowed values are prod, debug`,
+				"TELEGRAM_API_KEY can't be empty",
+				`DOWNLOAD_TIMEOUT: "5 hours" is not a duration like 30s, 5m or 1h`,
+				"AUDIO_FILE_MAX_SIZE_MB: -1 is out of range [0, 50]",
+				"DOWNLOAD_RETRY_MAX_DELAY (100ms) must not be less than DOWNLOAD_RETRY_BASE_DELAY (1s)",
+				`ADMIN_USER_IDS: "admin" is not an id`,
+				`MONITORING_LISTEN_ADDR: "9090" is not an address like :9090 or 127.0.0.1:9090`,
+			},
+		},
+		{
+			name: "should_validate_webhook_only_in_webhook_mode",
+			values: map[string]string{
+				"TELEGRAM_API_KEY": "key",
+				"TELEGRAM_MODE":    "webhook",
+			},
+			wantProblems: []string{"TELEGRAM_WEBHOOK_*: webhook url is not specified"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			for key, value := range tt.values {
+				v.Set(key, value)
+			}
+			c, err := Load(v)
+			var cfgErr *Error
+			if len(tt.wantProblems) > 0 {
+				if !errors.As(err, &cfgErr) {
+					t.Fatalf("Load() error = %v, want *Error", err)
+				}
+				if !reflect.DeepEqual(cfgErr.Problems, tt.wantProblems) {
+					t.Errorf("Load() problems = %q, want %q", cfgErr.Problems, tt.wantProblems)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestLoad_warnings(t *testing.T) {
+	v := viper.New()
+	v.Set("TELEGRAM_API_KEY", "key")
+	v.Set("MAX_CONCURRENT_JOBS", "2")
+	v.Set("ALLOWED_CHAT_IDS", "-100")
+	v.Set("STORAGE_DRIVER", "memory")
+	c, err := Load(v)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := []string{"Memory storage is used! Dialogs of users will be lost on restart."}
+	if !reflect.DeepEqual(c.Warnings, want) {
+		t.Errorf("Warnings = %q, want %q", c.Warnings, want)
+	}
+	v.Set("AUDIO_FILE_MAX_SIZE_MB", "0")
+	c, err = Load(v)
+	if err != nil {
+		t.Fatalf("Load() with zero AUDIO_FILE_MAX_SIZE_MB error = %v", err)
+	}
+	if c.AudioMaxFileSizeMB != defaultAudioMaxFileSizeMB || len(c.Warnings) != 2 {
+		t.Errorf("AudioMaxFileSizeMB = %d with warnings %q, want default size with warning", c.AudioMaxFileSizeMB, c.Warnings)
+	}
+	if c.MaxConcurrentJobs != 2 || c.RateLimitWindow != time.Minute {
+		t.Errorf("MaxConcurrentJobs = %d, RateLimitWindow = %v, want 2 and 1m", c.MaxConcurrentJobs, c.RateLimitWindow)
+	}
+}
@@ -3,31 +3,88 @@ package dialogs
 import (
 	"github.com/vm-affekt/tgytbot/internal/app"
 	"github.com/vm-affekt/tgytbot/internal/dialogs/download"
+	"github.com/vm-affekt/tgytbot/internal/dialogs/formatpicker"
 	"github.com/vm-affekt/tgytbot/internal/dialogs/maind"
+	"github.com/vm-affekt/tgytbot/internal/dialogs/playlist"
+	"github.com/vm-affekt/tgytbot/internal/dialogs/settings"
 	"time"
 )
 
+// historyLimit is the number of last downloads kept for each user.
+const historyLimit = 20
+
 // Container is DI-container of app
 type Container struct {
 	downloadService    app.DownloadService
+	downloadQueue      app.DownloadQueue
+	scheduler          app.JobScheduler
+	mediaCache         app.MediaCache
+	storage            app.Storage
+	jobs               *app.JobRegistry
+	settings           *app.UserSettingsStore
+	history            *app.DownloadHistory
+	quota              app.Quota
 	downloadTimeout    time.Duration
 	audioMaxFileSizeMB int64
 }
 
-func NewContainer(downloadService app.DownloadService, downloadTimeout time.Duration, audioMaxFileSizeMB int64) *Container {
+func NewContainer(downloadService app.DownloadService, downloadQueue app.DownloadQueue, scheduler app.JobScheduler, mediaCache app.MediaCache, storage app.Storage, quota app.Quota, downloadTimeout time.Duration, audioMaxFileSizeMB int64) *Container {
 	return &Container{
 		downloadService:    downloadService,
+		downloadQueue:      downloadQueue,
+		scheduler:          scheduler,
+		mediaCache:         mediaCache,
+		storage:            storage,
+		jobs:               app.NewJobRegistry(),
+		settings:           app.NewUserSettingsStore(),
+		history:            app.NewDownloadHistory(historyLimit),
+		quota:              quota,
 		downloadTimeout:    downloadTimeout,
 		audioMaxFileSizeMB: audioMaxFileSizeMB,
 	}
 }
 
+func (c *Container) DownloadQueue() app.DownloadQueue {
+	return c.downloadQueue
+}
+
+// Storage returns the storage of dialogs and active jobs.
+func (c *Container) Storage() app.Storage {
+	return c.storage
+}
+
+// Jobs returns the registry of downloads running in background.
+func (c *Container) Jobs() *app.JobRegistry {
+	return c.jobs
+}
+
+// History returns the last downloads of users.
+func (c *Container) History() *app.DownloadHistory {
+	return c.history
+}
+
+// Quota returns limits of downloads of users.
+func (c *Container) Quota() app.Quota {
+	return c.quota
+}
+
+// Commands returns commands declared by dialogs. They are shown in the menu of bot.
+func (c *Container) Commands() []app.Command {
+	return download.Commands
+}
+
 func (c *Container) CreateDialog(id app.DialogID, rup app.ReqUserProvider) app.Dialog {
 	switch id {
 	case app.DialogMain:
 		return maind.New(rup)
 	case app.DialogYoutubeDownload:
-		return download.New(rup, c.downloadService, c.downloadTimeout, c.audioMaxFileSizeMB)
+		return download.New(rup, c.downloadService, c.downloadQueue, c.scheduler, c.mediaCache, c.storage, c.jobs, c.settings, c.history, c.quota, c.downloadTimeout, c.audioMaxFileSizeMB)
+	case app.DialogFormatPicker:
+		return formatpicker.New(rup, c.downloadService, c.settings)
+	case app.DialogPlaylist:
+		return playlist.New(rup, c.downloadService)
+	case app.DialogSettings:
+		return settings.New(rup, c.settings)
 	}
 	return nil
 }
@@ -0,0 +1,105 @@
+package download
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/downloader"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+// mediaUploader uploads the media file to Telegram and returns its file_id.
+type mediaUploader func(ctx context.Context, stream io.Reader, fileName string, meta app.MediaMeta) (fileID string, err error)
+
+// uploadedFiles collects file_ids of uploaded files of the media in order of uploading.
+type uploadedFiles struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+// record returns mediaSender which remembers file_ids of files uploaded by upload.
+func (f *uploadedFiles) record(upload mediaUploader) mediaSender {
+	return func(ctx context.Context, stream io.Reader, fileName string, meta app.MediaMeta) error {
+		fileID, err := upload(ctx, stream, fileName, meta)
+		if err != nil {
+			return err
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.ids = append(f.ids, fileID)
+		return nil
+	}
+}
+
+func (f *uploadedFiles) fileIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.ids...)
+}
+
+// mediaCacheKey returns the key of requested media in cache. ok is false if media can't be cached.
+func (d *dialog) mediaCacheKey(ctx context.Context, req app.DownloadRequest) (key string, ok bool) {
+	videoID, err := downloader.ExtractVideoID(req.Link)
+	if err != nil {
+		logging.FromContextS(ctx).Warnf("Media can't be cached, failed to extract video id: %v", err)
+		return "", false
+	}
+	if req.Kind == app.MediaAudio {
+		// The default profile may be changed by server config, files of the old one mustn't be sent.
+		req.Options.AudioProfile = d.downloadService.AudioProfileName(req.Options.AudioProfile)
+	}
+	return app.MediaCacheKey(videoID, req, d.audioMaxFileSize), true
+}
+
+// sendCached sends files of the media uploaded before. ok is false if media isn't cached or Telegram
+// doesn't accept its files anymore, so media must be downloaded again.
+func (d *dialog) sendCached(ctx context.Context, key string) (title string, ok bool) {
+	log := logging.FromContextS(ctx)
+	media, ok, err := d.mediaCache.Get(ctx, key)
+	if err != nil {
+		log.Errorf("Failed to get media from cache: %v", err)
+		return "", false
+	}
+	if !ok {
+		return "", false
+	}
+	log.Infof("Media %q is found in cache, sending %d files uploaded before", key, len(media.FileIDs))
+	for i, fileID := range media.FileIDs {
+		if err := d.rup.SendMediaByFileID(ctx, media.Kind, fileID); err != nil {
+			log.Warnf("Failed to send cached file %d/%d, media will be downloaded again: %v", i+1, len(media.FileIDs), err)
+			if err := d.mediaCache.Invalidate(ctx, key); err != nil {
+				log.Errorf("Failed to invalidate cached media: %v", err)
+			}
+			if i > 0 {
+				_ = d.sendMsgf(ctx, "Не удалось отправить сохраненные ранее файлы, загружаем заново...")
+			}
+			return "", false
+		}
+	}
+	return media.Title, true
+}
+
+// cacheUploaded saves file_ids of the uploaded media, so it can be sent again without downloading.
+func (d *dialog) cacheUploaded(ctx context.Context, key string, kind app.MediaKind, title string, fileIDs []string) {
+	log := logging.FromContextS(ctx)
+	if len(fileIDs) == 0 {
+		return
+	}
+	for _, id := range fileIDs {
+		if id == "" {
+			log.Warn("Telegram didn't return file_id of uploaded file, media isn't cached")
+			return
+		}
+	}
+	err := d.mediaCache.Put(ctx, app.CachedMedia{
+		Key:     key,
+		Kind:    kind,
+		Title:   title,
+		FileIDs: fileIDs,
+	})
+	if err != nil {
+		log.Errorf("Failed to put media to cache: %v", err)
+	}
+}
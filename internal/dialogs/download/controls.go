@@ -0,0 +1,107 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+// Commands are handled by the download dialog.
+var Commands = []app.Command{
+	{Name: "cancel", Description: "Прервать текущую загрузку"},
+	{Name: "queue", Description: "Показать очередь загрузок"},
+}
+
+func (d *dialog) Commands() []app.Command {
+	return Commands
+}
+
+func (d *dialog) OnCommand(ctx context.Context, name, args string) error {
+	switch name {
+	case "cancel":
+		if !d.isDownloading() {
+			if err := d.sendMsgf(ctx, "Нет активных загрузок."); err != nil {
+				return err
+			}
+			_, err := d.rup.RedirectToDialog(ctx, app.DialogMain)
+			return err
+		}
+		if err := d.stopDownloading(ctx); err != nil {
+			return fmt.Errorf("failed to stop downloading: %w", err)
+		}
+	case "queue":
+		if err := d.showQueue(ctx); err != nil {
+			return fmt.Errorf("failed to show queue: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *dialog) OnCallback(ctx context.Context, data string, msgID int) error {
+	if !d.isDownloading() {
+		return app.NewInactiveButtonError()
+	}
+	if strings.HasPrefix(data, cbRemoveQueuePrefix) {
+		if msgID != d.queueMsgID {
+			return app.NewInactiveButtonError()
+		}
+		return d.removeFromQueue(ctx, strings.TrimPrefix(data, cbRemoveQueuePrefix))
+	}
+	d.statusMx.Lock()
+	progressMsgID := d.progressMsgID
+	d.statusMx.Unlock()
+	if msgID != progressMsgID {
+		return app.NewInactiveButtonError()
+	}
+	switch data {
+	case cbStop:
+		if err := d.stopDownloading(ctx); err != nil {
+			return fmt.Errorf("failed to stop downloading: %w", err)
+		}
+	case cbQueue:
+		if err := d.showQueue(ctx); err != nil {
+			return fmt.Errorf("failed to show queue: %w", err)
+		}
+	default:
+		return app.NewInactiveButtonError()
+	}
+	return nil
+}
+
+// sendProgressMsgf sends the message with buttons controlling the current download.
+// The message is updated with the status of download until the download is finished.
+// Buttons of the previous progress message are removed.
+func (d *dialog) sendProgressMsgf(ctx context.Context, text string, vals ...interface{}) error {
+	d.closeProgressMsg(ctx)
+	text = fmt.Sprintf(text, vals...)
+	shown := d.renderProgress(ctx, text)
+	msgID, err := d.rup.SendMessageWithInlineKeyboardf(ctx, &progressKeyboard, "%s", shown)
+	if err != nil {
+		return err
+	}
+	d.statusMx.Lock()
+	d.progressMsgID = msgID
+	d.progressText = text
+	d.progressShown = shown
+	d.statusMx.Unlock()
+	return nil
+}
+
+// closeProgressMsg removes buttons from the progress message when download is finished.
+func (d *dialog) closeProgressMsg(ctx context.Context) {
+	d.statusMx.Lock()
+	msgID, text := d.progressMsgID, d.progressText
+	d.progressMsgID = 0
+	d.statusMx.Unlock()
+	if msgID == 0 {
+		return
+	}
+	// The download may be already cancelled, but the message must be updated anyway.
+	ctx = logging.CopyContext(ctx, context.Background())
+	if err := d.rup.EditMessagef(ctx, msgID, nil, "%s", text); err != nil {
+		logging.FromContextS(ctx).Warnf("Failed to remove buttons from progress message: %v", err)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"math"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,18 +16,27 @@ import (
 	"github.com/vm-affekt/tgytbot/internal/dialogs/progress"
 	"github.com/vm-affekt/tgytbot/internal/downloader"
 	"github.com/vm-affekt/tgytbot/internal/logging"
+	"github.com/vm-affekt/tgytbot/internal/metrics"
 )
 
 const (
-	btnStop   = "Прервать"
-	btnStatus = "Статус"
+	btnStop  = "⏹ Прервать"
+	btnQueue = "📋 Очередь"
 )
 
-var keyboardOnWait = tgbotapi.NewOneTimeReplyKeyboard(
-	[]tgbotapi.KeyboardButton{
-		tgbotapi.NewKeyboardButton(btnStop),
-		tgbotapi.NewKeyboardButton(btnStatus),
-	},
+// Callback data of inline buttons.
+const (
+	cbStop              = "stop"
+	cbQueue             = "queue"
+	cbRemoveQueuePrefix = "rm:"
+)
+
+// progressKeyboard is attached to the message about started download.
+var progressKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(btnStop, cbStop),
+		tgbotapi.NewInlineKeyboardButtonData(btnQueue, cbQueue),
+	),
 )
 
 const defaultAudioMaxFileSizeMB = 48
@@ -33,19 +44,50 @@ const defaultAudioMaxFileSizeMB = 48
 type dialog struct {
 	rup                app.ReqUserProvider
 	downloadService    app.DownloadService
+	queue              app.DownloadQueue
+	scheduler          app.JobScheduler
+	mediaCache         app.MediaCache
+	jobStorage         app.JobStorage
+	jobs               *app.JobRegistry
+	settings           *app.UserSettingsStore
+	history            *app.DownloadHistory
+	quota              app.Quota
 	downloadingTimeout time.Duration
 	audioMaxFileSize   int64
 
 	statusMx             sync.Mutex
 	isDownloadInProgress bool
-	status               *downloadStatus
-	messagesToDelete     messagesToDelete
+	// stopped is true when user stopped downloading and left the dialog. Queue isn't processed after that.
+	stopped bool
+	// interrupted is true when downloading is interrupted by shutdown of bot.
+	interrupted bool
+	status      *downloadStatus
+	// batch is not nil while the batch of tracks is downloading.
+	batch            *batchStatus
+	messagesToDelete messagesToDelete
+	// progressMsgID is the id of message with buttons controlling the current download.
+	progressMsgID int
+	// progressText is the text of progress message without status.
+	progressText string
+	// progressShown is the whole text of progress message shown to user, it's used to skip needless edits.
+	progressShown string
+	// queueMsgID is the id of message with the list of queue.
+	queueMsgID int
 }
 
 type downloadStatus struct {
 	title           string
 	progressCounter *progress.Counter
-	cancel          func()
+	// sourceCounter counts bytes received from YouTube, it measures the speed of downloading
+	// while progressCounter measures the speed of the ready media.
+	sourceCounter *progress.Counter
+	cancel        func()
+}
+
+type batchStatus struct {
+	trackNum    int
+	tracksTotal int
+	cancel      func()
 }
 
 type messagesToDelete struct {
@@ -59,13 +101,16 @@ func (mtd *messagesToDelete) addMessage(id int) {
 	mtd.ids = append(mtd.ids, id)
 }
 
-func (mtd *messagesToDelete) getIDs() []int {
+// takeIDs returns ids of messages to delete and forgets them.
+func (mtd *messagesToDelete) takeIDs() []int {
 	mtd.mu.Lock()
 	defer mtd.mu.Unlock()
-	return mtd.ids
+	ids := mtd.ids
+	mtd.ids = nil
+	return ids
 }
 
-func New(rup app.ReqUserProvider, downloadService app.DownloadService, downloadingTimeout time.Duration, audioMaxFileSizeMB int64) app.Dialog {
+func New(rup app.ReqUserProvider, downloadService app.DownloadService, queue app.DownloadQueue, scheduler app.JobScheduler, mediaCache app.MediaCache, jobStorage app.JobStorage, jobs *app.JobRegistry, settings *app.UserSettingsStore, history *app.DownloadHistory, quota app.Quota, downloadingTimeout time.Duration, audioMaxFileSizeMB int64) app.DownloadDialog {
 	var audioMaxFileSize int64
 	if audioMaxFileSizeMB == 0 {
 		audioMaxFileSize = megabytesToBytes(defaultAudioMaxFileSizeMB)
@@ -75,6 +120,14 @@ func New(rup app.ReqUserProvider, downloadService app.DownloadService, downloadi
 	return &dialog{
 		rup:                rup,
 		downloadService:    downloadService,
+		queue:              queue,
+		scheduler:          scheduler,
+		mediaCache:         mediaCache,
+		jobStorage:         jobStorage,
+		jobs:               jobs,
+		settings:           settings,
+		history:            history,
+		quota:              quota,
 		downloadingTimeout: downloadingTimeout,
 		audioMaxFileSize:   audioMaxFileSize,
 	}
@@ -87,22 +140,50 @@ func (d *dialog) OnEnter(ctx context.Context) error {
 }
 
 func (d *dialog) OnMessage(ctx context.Context, text string, msgID int) error {
-	if !d.isDownloading() {
-		go d.startAudioDownloading(ctx, text)
-	} else {
+	if d.isDownloading() {
 		d.messagesToDelete.addMessage(msgID)
 		return d.onDownloading(ctx, text)
 	}
+	req := app.DownloadRequest{Link: text, Kind: app.MediaAudio}
+	return d.start(ctx, app.QueuedDownload{Request: &req})
+}
+
+// StartDownloading starts downloading in background with options chosen by user in another dialog.
+// The request is put to the queue if another downloading is in progress.
+func (d *dialog) StartDownloading(ctx context.Context, req app.DownloadRequest) error {
+	return d.start(ctx, app.QueuedDownload{Request: &req})
+}
+
+// StartBatchDownloading starts downloading of the batch in background.
+// The batch is put to the queue if another downloading is in progress.
+func (d *dialog) StartBatchDownloading(ctx context.Context, batch app.DownloadBatch) error {
+	return d.start(ctx, app.QueuedDownload{Title: batch.Title, Batch: &batch})
+}
+
+// start runs the job in background or enqueues it if another job is running.
+func (d *dialog) start(ctx context.Context, job app.QueuedDownload) error {
+	if err := d.quota.CheckRate(d.rup.User().ID); err != nil {
+		return err
+	}
+	if !d.tryStartDownloading() {
+		return d.enqueue(ctx, job)
+	}
+	done, ok := d.jobs.Register(d.interrupt)
+	if !ok {
+		d.finishDownloading()
+		return d.enqueueOnShutdown(ctx, job)
+	}
+	go d.runQueue(ctx, job, done)
 	return nil
 }
 
-func (d *dialog) sendMsgWithKeyboardf(ctx context.Context, text string, vals ...interface{}) (err error) {
-	_, err = d.rup.SendMessageWithKeyboardf(ctx, &keyboardOnWait, text, vals...)
+func (d *dialog) sendMsgf(ctx context.Context, text string, vals ...interface{}) (err error) {
+	_, err = app.SendMessagef(ctx, d.rup, text, vals...)
 	return err
 }
 
-func (d *dialog) sendMsgWithKeyboardThenDeletef(ctx context.Context, text string, vals ...interface{}) (err error) {
-	msgID, err := d.rup.SendMessageWithKeyboardf(ctx, &keyboardOnWait, text, vals...)
+func (d *dialog) sendMsgThenDeletef(ctx context.Context, text string, vals ...interface{}) (err error) {
+	msgID, err := app.SendMessagef(ctx, d.rup, text, vals...)
 	if err != nil {
 		return err
 	}
@@ -116,129 +197,364 @@ func (d *dialog) isDownloading() bool {
 	return d.isDownloadInProgress
 }
 
-func (d *dialog) printCurrentDownloadStatus(ctx context.Context) error {
-	log := logging.FromContextS(ctx)
-	log.Info("User requested progress status of downloading.")
-	pc := d.status.progressCounter
-	contentLen := pc.ContentLen()
-	currentDownloaded := pc.CurrentDownloaded()
-	contentLenMB, currentDownloadedMB := bytesToMegabytes(contentLen), bytesToMegabytes(currentDownloaded)
-	if contentLen == 0 {
-		return d.sendMsgWithKeyboardThenDeletef(ctx, "На данный момент загружено <b>%.2fMB</b>. Определить прогресс в процентах для данного видео невозможно...", currentDownloadedMB)
-	}
-	var estimatedTimeS string
-	estimatedTime, err := pc.EstimatedTime()
-	if err != nil {
-		log.Warnf("Failed to count estimated time by reason: %v", err)
-		estimatedTimeS = "???"
-	} else {
-		estimatedTime = estimatedTime.Round(time.Second)
-		estimatedTimeS = fmt.Sprintf("%s", estimatedTime)
+// tryStartDownloading marks the dialog as downloading. It returns false if downloading is already in progress.
+func (d *dialog) tryStartDownloading() bool {
+	d.statusMx.Lock()
+	defer d.statusMx.Unlock()
+	if d.isDownloadInProgress {
+		return false
 	}
-	return d.sendMsgWithKeyboardThenDeletef(ctx, "На данный момент загружено\n<i>%.2fMB</i> из <i>%.2fMB</i>: <b>%.2f%%</b>\nПриблизительно осталось: <b>%s</b>", currentDownloadedMB, contentLenMB, pc.Percentage(), estimatedTimeS)
+	d.isDownloadInProgress = true
+	return true
+}
+
+func (d *dialog) finishDownloading() {
+	d.statusMx.Lock()
+	defer d.statusMx.Unlock()
+	d.isDownloadInProgress = false
+}
+
+// interrupt cancels downloading on shutdown of bot. The job is put back to the queue by runQueue.
+func (d *dialog) interrupt() {
+	d.statusMx.Lock()
+	defer d.statusMx.Unlock()
+	d.interrupted = true
+	if d.batch != nil {
+		d.batch.cancel()
+	}
+	if d.status != nil {
+		d.status.cancel()
+	}
+}
+
+func (d *dialog) isInterrupted() bool {
+	d.statusMx.Lock()
+	defer d.statusMx.Unlock()
+	return d.interrupted
 }
 
 func (d *dialog) onDownloading(ctx context.Context, text string) error {
 	if err := downloader.ValidateLink(text); err == nil {
-		return d.sendMsgWithKeyboardThenDeletef(ctx, "Вы не можете скачивать другие видео/аудио, пока не завершится текущая загрузка! Вы можете ее отменить.")
-	}
-	if text == btnStop {
-		if err := d.stopDownloading(ctx); err != nil {
-			return fmt.Errorf("failed to stop downloading: %w", err)
+		if err := d.quota.CheckRate(d.rup.User().ID); err != nil {
+			return err
 		}
-		return nil
+		req := app.DownloadRequest{Link: text, Kind: app.MediaAudio}
+		return d.enqueue(ctx, app.QueuedDownload{Request: &req})
 	}
-	if err := d.printCurrentDownloadStatus(ctx); err != nil {
-		return fmt.Errorf("failed to print current download status: %w", err)
+	if _, err := downloader.ExtractPlaylistID(text); err == nil {
+		return d.sendMsgThenDeletef(ctx, "Плейлист можно будет скачать после завершения текущих загрузок. Вы можете их прервать.")
 	}
-	return nil
+	return d.sendMsgThenDeletef(ctx, "Прогресс загрузки обновляется в сообщении выше. Чтобы прервать загрузку, нажмите кнопку под ним.")
 }
 
-func (d *dialog) startAudioDownloading(ctx context.Context, link string) {
+// downloadSingle downloads the media and reports the error to user.
+func (d *dialog) downloadSingle(ctx context.Context, req app.DownloadRequest) (err error) {
 	log := logging.FromContextS(ctx)
 	startT := time.Now()
-	d.statusMx.Lock()
-	d.isDownloadInProgress = true
-	d.statusMx.Unlock()
 	defer func() {
-		log.Infof("Elapsed time of dowloading audio %q is %v", link, time.Since(startT).String())
-		_, _ = d.rup.RedirectToDialog(ctx, app.DialogMain)
+		log.Infof("Elapsed time of dowloading %s %q is %v", mediaNoun(req.Kind), req.Link, time.Since(startT).String())
 	}()
-	if err := d.downloadAudio(ctx, link); err != nil {
-		log.Errorf("Failed to download audio %q: %v", link, err)
+	d.statusMx.Lock()
+	d.status = nil
+	d.statusMx.Unlock()
+	if err = d.download(ctx, req); err != nil {
+		log.Errorf("Failed to download %s %q: %v", mediaNoun(req.Kind), req.Link, err)
+		if d.isInterrupted() {
+			return err
+		}
+		var usrErr *app.UserError
+		if errors.As(err, &usrErr) {
+			_, _ = app.SendMessagef(context.Background(), d.rup, "%s", usrErr.UserMessage)
+			return err
+		}
 		var textMsg string
-		if d.status != nil {
-			textMsg = fmt.Sprintf("При скачивании аудио из видео <b>%q</b> произошла техническая ошибка. Повторите попытку позже!", d.status.title)
+		if d.status != nil && d.status.title != "" {
+			textMsg = fmt.Sprintf("При скачивании %s <b>%q</b> произошла техническая ошибка. Повторите попытку позже!", mediaOfVideo(req.Kind), d.status.title)
+		} else if req.Kind == app.MediaVideo {
+			textMsg = "При скачивании данного видео произошла техническая ошибка. Повторите попытку позже!"
 		} else {
 			textMsg = "При скачивании аудио из данного видео произошла техническая ошибка. Повторите попытку позже!"
 		}
 		textMsg += fmt.Sprintf("\n\nТекст ошибки:\n<code>%s</code>", err.Error())
 		_, _ = app.SendMessagef(context.Background(), d.rup, textMsg) // TODO: КОД ОШИБКИ!
 	}
+	return err
 }
 
-func (d *dialog) downloadAudio(msgCtx context.Context, link string) error {
-	ctx := logging.CopyContext(msgCtx, context.Background())
-	var cancel func()
-	if d.downloadingTimeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, d.downloadingTimeout)
-	} else {
-		ctx, cancel = context.WithCancel(ctx)
+// downloadBatch downloads items of the batch one by one and reports about failed ones.
+// It returns the index of the item which was downloading when the batch was cancelled,
+// or the number of items if the batch is finished.
+func (d *dialog) downloadBatch(ctx context.Context, batch app.DownloadBatch) (stoppedAt int) {
+	log := logging.FromContextS(ctx)
+	startT := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	d.statusMx.Lock()
+	d.batch = &batchStatus{
+		tracksTotal: len(batch.Items),
+		cancel:      cancel,
 	}
+	d.statusMx.Unlock()
+	defer func() {
+		cancel()
+		d.statusMx.Lock()
+		d.batch = nil
+		d.statusMx.Unlock()
+		log.Infof("Elapsed time of dowloading batch %q is %v", batch.Title, time.Since(startT).String())
+	}()
+
+	total := len(batch.Items)
+	var failed []string
+	for i, item := range batch.Items {
+		d.statusMx.Lock()
+		d.batch.trackNum = i + 1
+		d.status = nil
+		d.statusMx.Unlock()
+		ctx := logging.NewContextS(ctx, "track_num", i+1)
+		err := d.download(ctx, item.Request)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			log.Infof("Downloading of batch %q is stopped on track %d/%d", batch.Title, i+1, total)
+			return i
+		}
+		if errors.Is(err, app.ErrQuotaExceeded) {
+			log.Infof("Downloading of batch %q is stopped on track %d/%d by quota", batch.Title, i+1, total)
+			quotaMsg := "Вы исчерпали лимит загрузок.\n\nОстаток лимитов: /quota"
+			var usrErr *app.UserError
+			if errors.As(err, &usrErr) {
+				quotaMsg = usrErr.UserMessage
+			}
+			_, _ = app.SendMessagef(ctx, d.rup, "%s\n\nОставшиеся треки плейлиста <b>%q</b> не будут скачаны.", quotaMsg, batch.Title)
+			return total
+		}
+		if err != nil {
+			log.Errorf("Failed to download track %d/%d %q: %v", i+1, total, item.Request.Link, err)
+			failed = append(failed, fmt.Sprintf("%d. %s", i+1, html.EscapeString(item.Title)))
+			textMsg := fmt.Sprintf("Трек <b>%d/%d</b> <b>%q</b> не удалось скачать, он будет пропущен.\n\nТекст ошибки:\n<code>%s</code>", i+1, total, item.Title, err.Error())
+			_, _ = app.SendMessagef(ctx, d.rup, "%s", textMsg)
+		}
+	}
+
+	if len(failed) == 0 {
+		_, _ = app.SendMessagef(ctx, d.rup, "Плейлист <b>%q</b> полностью загружен: <b>%d</b> треков.", batch.Title, total)
+		return total
+	}
+	_, _ = app.SendMessagef(ctx, d.rup, "Плейлист <b>%q</b> загружен: успешно <b>%d</b> из <b>%d</b> треков.\n\nНе удалось скачать:\n%s", batch.Title, total-len(failed), total, strings.Join(failed, "\n"))
+	return total
+}
+
+// trackPrefix returns text like "Трек 7/42. " while the batch is downloading.
+func (d *dialog) trackPrefix() string {
+	d.statusMx.Lock()
+	defer d.statusMx.Unlock()
+	if d.batch == nil {
+		return ""
+	}
+	return fmt.Sprintf("Трек <b>%d/%d</b>. ", d.batch.trackNum, d.batch.tracksTotal)
+}
+
+// download downloads and uploads the media. ctx shouldn't be bound to the incoming message, because
+// downloading lasts longer than message processing.
+func (d *dialog) download(ctx context.Context, req app.DownloadRequest) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	d.statusMx.Lock()
+	d.status = &downloadStatus{cancel: cancel}
+	d.statusMx.Unlock()
+	defer d.closeProgressMsg(ctx)
+	defer d.startProgressUpdates(ctx)()
 	log := logging.FromContextS(ctx)
-	log.Infof("Starting download audio by link: %q", link)
-	downloadRes, err := d.downloadService.DownloadAudio(ctx, link)
+
+	// Profile chosen in settings is used only with the default source format, other formats may be incompatible with it.
+	if req.Kind == app.MediaAudio && req.Options.AudioProfile == "" && req.Options.Itag == 0 {
+		req.Options.AudioProfile = d.settings.Get(d.rup.User().ID).AudioProfile
+	}
+	cacheKey, cacheable := d.mediaCacheKey(ctx, req)
+	if cacheable {
+		if title, ok := d.sendCached(ctx, cacheKey); ok {
+			return d.reportDownloaded(ctx, req, title)
+		}
+	}
+
+	// Media sent from cache isn't downloaded, so it doesn't spend the quota.
+	if err := d.quota.Check(d.rup.User().ID); err != nil {
+		return err
+	}
+
+	// Waiting for the free slot isn't limited by downloading timeout.
+	release, err := d.scheduler.Acquire(ctx, d.rup.User().ID, func(position int) {
+		log.Infof("Download is waiting in line at position %d", position)
+		if err := d.sendProgressMsgf(ctx, "%sСейчас бот загружает файлы других пользователей. Ваша загрузка начнется автоматически.", d.trackPrefix()); err != nil {
+			log.Errorf("Failed to notify user about waiting: %v", err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for free downloading slot: %w", err)
+	}
+	defer release()
+	if d.downloadingTimeout > 0 {
+		var cancelTimeout func()
+		ctx, cancelTimeout = context.WithTimeout(ctx, d.downloadingTimeout)
+		defer cancelTimeout()
+	}
+	log.Infof("Starting download %s by link: %q", mediaNoun(req.Kind), req.Link)
+	sourceCounter := progress.NewCounter(0)
+	req.Options.Progress = io.MultiWriter(sourceCounter, metrics.ByteCounter{Counter: metrics.DownloadedBytes})
+	metrics.DownloadsStarted.WithLabelValues(req.Kind.String()).Inc()
+	defer func() {
+		metrics.DownloadsFinished.WithLabelValues(req.Kind.String(), downloadResult(ctx, err)).Inc()
+	}()
+	var (
+		downloadRes app.DownloadResult
+		uploaded    uploadedFiles
+		sendMedia   mediaSender
+	)
+	switch req.Kind {
+	case app.MediaVideo:
+		downloadRes, err = d.downloadService.DownloadVideo(ctx, req.Link, req.Options)
+		sendMedia = uploaded.record(func(ctx context.Context, stream io.Reader, fileName string, _ app.MediaMeta) (string, error) {
+			return d.rup.SendVideo(ctx, stream, fileName)
+		})
+	default:
+		downloadRes, err = d.downloadService.DownloadAudio(ctx, req.Link, req.Options)
+		sendMedia = uploaded.record(d.rup.SendAudio)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to download audio: %w", err)
+		return fmt.Errorf("failed to download %s: %w", mediaNoun(req.Kind), err)
 	}
-	progressCounter := progress.NewCounter(downloadRes.ContentLen)
+	defer downloadRes.Stream.Close()
+	var progressCounter *progress.Counter
+	if req.Options.Clip.IsZero() {
+		progressCounter = progress.NewCounter(downloadRes.ContentLen)
+	} else {
+		progressCounter = progress.NewClipCounter(downloadRes.ContentLen, downloadRes.SourceDuration, req.Options.Clip.Duration(downloadRes.SourceDuration))
+	}
+	d.statusMx.Lock()
 	d.status = &downloadStatus{
 		title:           downloadRes.Name,
 		progressCounter: progressCounter,
+		sourceCounter:   sourceCounter,
 		cancel:          cancel,
 	}
+	d.statusMx.Unlock()
+	defer func() {
+		metrics.UploadedBytes.WithLabelValues(req.Kind.String()).Add(float64(progressCounter.CurrentDownloaded()))
+	}()
+	streamTee := io.TeeReader(downloadRes.Stream, d.status.progressCounter)
 
-	var partsCount int64
-
-	isMultipart := true
-	isKnownTotalSize := downloadRes.ContentLen > 0
-	if isKnownTotalSize {
-		partsCount = int64(math.Ceil(float64(downloadRes.ContentLen) / float64(d.audioMaxFileSize)))
-		isMultipart = partsCount > 1
-	}
-	startMsg := "Загрузка аудио началась. Вы можете отменить или узнать статус загрузки, нажав соответствующие кнопки на клавиатуре."
-	if isMultipart {
-		if isKnownTotalSize {
-			startMsg += fmt.Sprintf("\n\nИз-за ограничения Telegram для загрузки медиафайлов ботами, данное аудио будет разбито на <b>%d</b> частей.\nОни будут отправлены вам по мере готовности каждой отдельной записи.", partsCount)
+	noun := mediaNoun(req.Kind)
+	if req.Options.SplitByChapters && len(downloadRes.Chapters) > 0 {
+		if err := d.uploadChapters(ctx, streamTee, downloadRes, sendMedia); err != nil {
+			return err
+		}
+	} else {
+		contentLen := progressCounter.ContentLen()
+		partsCount, isMultipart := d.countParts(contentLen)
+		startMsg := d.trackPrefix() + fmt.Sprintf("Загрузка %s началась. Прогресс загрузки будет обновляться в этом сообщении.", noun)
+		// Audio is split at quiet moments into standalone files, so it can be sent only after the whole download.
+		splitAtSilence := isMultipart && req.Kind == app.MediaAudio
+		if splitAtSilence {
+			if partsCount > 0 {
+				startMsg += fmt.Sprintf("\n\nИз-за ограничения Telegram для загрузки медиафайлов ботами, данное аудио будет разбито примерно на <b>%d</b> частей по паузам в записи.\nОни будут отправлены вам после скачивания всего аудио.", partsCount)
+			} else {
+				startMsg += "\n\nИз-за ограничения Telegram для загрузки медиафайлов ботами, данное аудио может быть разбито на части по паузам в записи, т.к у данного видео невозможно определить размер.\nОни будут отправлены вам после скачивания всего аудио."
+			}
+		} else if isMultipart {
+			if partsCount > 0 {
+				startMsg += fmt.Sprintf("\n\nИз-за ограничения Telegram для загрузки медиафайлов ботами, данное %s будет разбито на <b>%d</b> частей.\nОни будут отправлены вам по мере готовности каждой отдельной записи.", noun, partsCount)
+			} else {
+				startMsg += fmt.Sprintf("\n\nИз-за ограничения Telegram для загрузки медиафайлов ботами, данное %s может быть разбито на неопределенное количество частей, т.к у данного видео невозможно определить размер.\nОни будут отправлены вам по мере готовности каждой отдельной записи.", noun)
+			}
+		}
+		if err := d.sendProgressMsgf(ctx, "%s", startMsg); err != nil {
+			return err
+		}
+		if splitAtSilence {
+			if err := d.uploadAtSilence(ctx, streamTee, downloadRes, sendMedia); err != nil {
+				return err
+			}
 		} else {
-			startMsg += "\n\nИз-за ограничения Telegram для загрузки медиафайлов ботами, данное аудио может быть разбито на неопределенное количество частей, т.к у данного видео невозможно определить размер.\nОни будут отправлены вам по мере готовности каждой отдельной записи."
+			fileName := fmt.Sprintf("%s.%s", downloadRes.Name, downloadRes.Ext)
+			if err := d.uploadBySize(ctx, streamTee, fileName, downloadRes.Meta, contentLen, noun, sendMedia); err != nil {
+				return err
+			}
 		}
 	}
-	if err := d.sendMsgWithKeyboardf(ctx, startMsg); err != nil {
+
+	log.Info("Successfully downloaded!")
+	usage := app.QuotaUsage{Downloads: 1, Bytes: progressCounter.CurrentDownloaded()}
+	if req.Kind == app.MediaAudio {
+		usage.AudioDuration = req.Options.Clip.Duration(downloadRes.SourceDuration)
+	}
+	if err := d.quota.Record(ctx, d.rup.User().ID, usage); err != nil {
+		log.Errorf("Failed to record quota usage: %v", err)
+	}
+	if cacheable {
+		d.cacheUploaded(ctx, cacheKey, req.Kind, d.status.title, uploaded.fileIDs())
+	}
+	return d.reportDownloaded(ctx, req, d.status.title)
+}
+
+// reportDownloaded notifies user that the media is completely sent and adds it to the history.
+func (d *dialog) reportDownloaded(ctx context.Context, req app.DownloadRequest, title string) error {
+	log := logging.FromContextS(ctx)
+	d.history.Add(d.rup.User().ID, app.HistoryEntry{
+		Title:        title,
+		Link:         req.Link,
+		Kind:         req.Kind,
+		DownloadedAt: time.Now(),
+	})
+	if _, err := app.SendMessagef(ctx, d.rup, "%s%s <b>%q</b> успешно и полностью загружено!", d.trackPrefix(), capitalize(mediaOfVideo(req.Kind)), title); err != nil {
 		return err
 	}
-	audioStreamTee := io.TeeReader(downloadRes.Stream, d.status.progressCounter)
+	go func() {
+		if err := d.clearMessages(ctx); err != nil {
+			log.Errorf("Failed to delete messages: %v", err)
+		}
+	}()
+	return nil
+}
+
+// mediaSender uploads the media file to Telegram.
+type mediaSender func(ctx context.Context, stream io.Reader, fileName string, meta app.MediaMeta) error
+
+// countParts returns the number of parts the media of contentLen bytes will be split to.
+// Zero partsCount means that content length is unknown, so media may be multipart.
+func (d *dialog) countParts(contentLen int64) (partsCount int64, isMultipart bool) {
+	if contentLen <= 0 {
+		return 0, true
+	}
+	partsCount = int64(math.Ceil(float64(contentLen) / float64(d.audioMaxFileSize)))
+	return partsCount, partsCount > 1
+}
+
+// uploadBySize uploads the stream splitting it to parts of audioMaxFileSize bytes.
+func (d *dialog) uploadBySize(ctx context.Context, stream io.Reader, baseFileName string, baseMeta app.MediaMeta, contentLen int64, noun string, sendMedia mediaSender) error {
+	log := logging.FromContextS(ctx)
+	partsCount, isMultipart := d.countParts(contentLen)
+	isKnownTotalSize := partsCount > 0
 
 	partNum := 1
 	for {
 		ctx := logging.NewContextS(ctx,
 			"part_num", partNum,
 		)
-		fileName := fmt.Sprintf("%s.mp3", downloadRes.Name)
+		fileName := baseFileName
+		meta := baseMeta
 		if isMultipart {
 			fileName = fmt.Sprintf("p%d_", partNum) + fileName
+			// Duration of the part is unknown, so Telegram will detect it by itself.
+			meta.Title = fmt.Sprintf("%s (%d)", meta.Title, partNum)
+			meta.Duration = 0
 		}
-		log.Infof("Began to upload audio part %d...", partNum)
-		audioUploadDone := make(chan error)
+		log.Infof("Began to upload %s part %d...", noun, partNum)
+		uploadDone := make(chan error)
 		pReader, pWriter := io.Pipe()
 		go func() {
-			if err := d.rup.SendAudio(ctx, pReader, fileName); err != nil {
-				audioUploadDone <- fmt.Errorf("failed to send audio: %w", err)
+			if err := sendMedia(ctx, pReader, fileName, meta); err != nil {
+				uploadDone <- fmt.Errorf("failed to send %s: %w", noun, err)
 			}
-			audioUploadDone <- nil
+			uploadDone <- nil
 		}()
-		written, err := io.CopyN(pWriter, audioStreamTee, d.audioMaxFileSize)
+		written, err := io.CopyN(pWriter, stream, d.audioMaxFileSize)
 		var lastPart bool
 		if err != nil {
 			if errors.Is(err, io.EOF) {
@@ -247,53 +563,63 @@ func (d *dialog) downloadAudio(msgCtx context.Context, link string) error {
 			} else {
 				return fmt.Errorf("failed to copyN bytes to upload stream of part %d: %w", partNum, err)
 			}
+		} else if !isMultipart {
+			// contentLen is the size of source stream, and the transcoded media may be bigger, e.g. flac.
+			// The rest is uploaded as the next parts.
+			log.Warnf("%s is bigger than expected %.2f MB, it will be split by size", noun, bytesToMegabytes(contentLen))
+			isMultipart = true
+			isKnownTotalSize = false
 		}
 		log.Infof("Copied %d bytes (%.2f MB) to pipe writer. Waiting for upload done...", written, bytesToMegabytes(written))
 		if err := pWriter.Close(); err != nil {
 			return fmt.Errorf("failed to close pipe writer for uploader of part %d: %w", partNum, err)
 		}
-		if err := <-audioUploadDone; err != nil {
-			return fmt.Errorf("failed to sendAudio of part %d: %w", partNum, err)
+		if err := <-uploadDone; err != nil {
+			return fmt.Errorf("failed to send %s of part %d: %w", noun, partNum, err)
 		}
 		if isMultipart {
 			if isKnownTotalSize {
-				if err := d.sendMsgWithKeyboardf(ctx, `<b>%d/%d</b> часть вашего аудио успешно загружена!`, partNum, partsCount); err != nil {
+				if err := d.sendMsgf(ctx, `<b>%d/%d</b> часть вашего %s успешно загружена!`, partNum, partsCount, noun); err != nil {
 					return err
 				}
 			} else {
-				if err := d.sendMsgWithKeyboardf(ctx, "<b>%d</b> часть вашего аудио успешно загружена!", partNum); err != nil {
+				if err := d.sendMsgf(ctx, "<b>%d</b> часть вашего %s успешно загружена!", partNum, noun); err != nil {
 					return err
 				}
 			}
 		}
-		log.Info("Audio part upload done successfully!")
+		log.Infof("%s part upload done successfully!", noun)
 		if lastPart {
-			break
+			return nil
 		}
 
 		partNum++
 	}
-
-	log.Info("Successfully downloaded!")
-	if _, err := app.SendMessagef(ctx, d.rup, "Аудио из видео <b>%q</b> успешно и полностью загружено!", d.status.title); err != nil {
-		return err
-	}
-	go func() {
-		if err := d.clearMessages(ctx); err != nil {
-			log.Errorf("Failed to delete messages: %v", err)
-		}
-	}()
-	return nil
 }
 
 func (d *dialog) clearMessages(ctx context.Context) error {
-	return d.rup.DeleteMessages(ctx, d.messagesToDelete.getIDs()...)
+	return d.rup.DeleteMessages(ctx, d.messagesToDelete.takeIDs()...)
 }
 
 func (d *dialog) stopDownloading(ctx context.Context) error {
 	log := logging.FromContextS(ctx)
 	log.Info("User requested to stop downloading!")
-	d.status.cancel()
+	queued, err := d.queue.List(ctx, d.rup.User().ID)
+	if err != nil {
+		return fmt.Errorf("failed to list queue: %w", err)
+	}
+	d.statusMx.Lock()
+	if d.batch != nil {
+		d.batch.cancel()
+	}
+	if d.status != nil {
+		d.status.cancel()
+	}
+	d.stopped = len(queued) == 0
+	d.statusMx.Unlock()
+	if len(queued) > 0 {
+		return d.sendMsgf(ctx, "Вы успешно прервали загрузку. Следующая загрузка из очереди начнется автоматически.")
+	}
 	if _, err := app.SendMessagef(ctx, d.rup, "Вы успешно прервали загрузку."); err != nil {
 		return err
 	}
@@ -303,6 +629,42 @@ func (d *dialog) stopDownloading(ctx context.Context) error {
 	return nil
 }
 
+// downloadResult returns the result of download for metrics: succeeded, failed or cancelled.
+func downloadResult(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "succeeded"
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return "cancelled"
+	default:
+		return "failed"
+	}
+}
+
+// mediaNoun returns russian noun for the kind of media.
+func mediaNoun(kind app.MediaKind) string {
+	if kind == app.MediaVideo {
+		return "видео"
+	}
+	return "аудио"
+}
+
+// mediaOfVideo returns phrase like "аудио из видео" to name downloaded media in messages.
+func mediaOfVideo(kind app.MediaKind) string {
+	if kind == app.MediaVideo {
+		return "видео"
+	}
+	return "аудио из видео"
+}
+
+func capitalize(s string) string {
+	r := []rune(s)
+	if len(r) == 0 {
+		return s
+	}
+	return strings.ToUpper(string(r[0])) + string(r[1:])
+}
+
 const oneMB = 1048576
 
 func bytesToMegabytes(bytes int64) float64 {
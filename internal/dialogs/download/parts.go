@@ -0,0 +1,91 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+// uploadChapters uploads one audio file per chapter.
+func (d *dialog) uploadChapters(ctx context.Context, stream io.Reader, res app.DownloadResult, sendMedia mediaSender) error {
+	if err := d.sendProgressMsgf(ctx, "%sЗагрузка аудио началась. Прогресс загрузки будет обновляться в этом сообщении.\n\nАудио будет разбито по главам видео на <b>%d</b> файлов. Они будут отправлены вам после скачивания всего аудио.", d.trackPrefix(), len(res.Chapters)); err != nil {
+		return err
+	}
+	parts := d.downloadService.SplitByChapters(ctx, stream, res)
+	err := d.uploadParts(ctx, parts, sendMedia,
+		func(part app.MediaPart) string {
+			return fmt.Sprintf("%02d. %s.%s", part.Num, part.Name, res.Ext)
+		},
+		func(part app.MediaPart) error {
+			return d.sendMsgf(ctx, "<b>%d/%d</b> глава успешно загружена: <i>%s</i>", part.Num, part.Total, html.EscapeString(part.Name))
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to upload chapters: %w", err)
+	}
+	return nil
+}
+
+// uploadAtSilence uploads the audio split into standalone parts at quiet moments.
+func (d *dialog) uploadAtSilence(ctx context.Context, stream io.Reader, res app.DownloadResult, sendMedia mediaSender) error {
+	parts := d.downloadService.SplitAtSilence(ctx, stream, res, d.audioMaxFileSize)
+	err := d.uploadParts(ctx, parts, sendMedia,
+		func(part app.MediaPart) string {
+			if part.Total == 1 {
+				return fmt.Sprintf("%s.%s", part.Name, res.Ext)
+			}
+			return fmt.Sprintf("p%d_%s.%s", part.Num, part.Name, res.Ext)
+		},
+		func(part app.MediaPart) error {
+			if part.Total == 1 {
+				return nil
+			}
+			return d.sendMsgf(ctx, `<b>%d/%d</b> часть вашего аудио успешно загружена!`, part.Num, part.Total)
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to upload audio parts: %w", err)
+	}
+	return nil
+}
+
+// uploadParts uploads standalone parts produced by the splitter. Parts which are still bigger than Telegram limit
+// are split by size.
+func (d *dialog) uploadParts(
+	ctx context.Context,
+	parts <-chan app.MediaPart,
+	sendMedia mediaSender,
+	fileNameOf func(part app.MediaPart) string,
+	onUploaded func(part app.MediaPart) error,
+) error {
+	log := logging.FromContextS(ctx)
+	for part := range parts {
+		if part.Err != nil {
+			return fmt.Errorf("failed to split audio: %w", part.Err)
+		}
+		ctx := logging.NewContextS(ctx, "part_num", part.Num)
+		fileName := fileNameOf(part)
+		var err error
+		if part.Size > d.audioMaxFileSize {
+			log.Infof("Part %d is bigger than limit (%.2f MB), it will be split by size", part.Num, bytesToMegabytes(part.Size))
+			err = d.uploadBySize(ctx, part.Stream, fileName, part.Meta, part.Size, mediaNoun(app.MediaAudio), sendMedia)
+		} else {
+			err = sendMedia(ctx, part.Stream, fileName, part.Meta)
+		}
+		_ = part.Stream.Close()
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", part.Num, err)
+		}
+		if err := onUploaded(part); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("splitting is interrupted: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+	"github.com/vm-affekt/tgytbot/internal/metrics"
+)
+
+// maxQueueBtnTitleLen limits the length of video title in buttons of the queue list.
+const maxQueueBtnTitleLen = 40
+
+// ResumeQueue starts processing of the user's queue left after the restart of bot.
+func (d *dialog) ResumeQueue(ctx context.Context) error {
+	if !d.tryStartDownloading() {
+		return nil
+	}
+	done, ok := d.jobs.Register(d.interrupt)
+	if !ok {
+		d.finishDownloading()
+		return nil
+	}
+	job, ok, err := d.queue.Pop(ctx, d.rup.User().ID)
+	if err != nil || !ok {
+		d.finishDownloading()
+		done()
+		if err != nil {
+			return fmt.Errorf("failed to pop download from queue: %w", err)
+		}
+		return nil
+	}
+	logging.FromContextS(ctx).Infof("Resuming queue of user from download %q", job.Title)
+	go d.runQueue(ctx, job, done)
+	if err := d.sendMsgf(ctx, "Бот был перезапущен. Продолжаем загрузки из вашей очереди: <b>%s</b>", html.EscapeString(job.Title)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// enqueue puts the job to the end of the user's queue.
+func (d *dialog) enqueue(ctx context.Context, job app.QueuedDownload) error {
+	job, position, err := d.pushToQueue(ctx, job)
+	if err != nil {
+		return err
+	}
+	return d.sendMsgThenDeletef(ctx, "<b>%s</b> добавлено в очередь. Позиция в очереди: <b>%d</b>.\nЗагрузка начнется автоматически после завершения текущих.", html.EscapeString(job.Title), position)
+}
+
+// enqueueOnShutdown puts the job to the queue when bot is shutting down. The job will be started after restart.
+func (d *dialog) enqueueOnShutdown(ctx context.Context, job app.QueuedDownload) error {
+	job, _, err := d.pushToQueue(ctx, job)
+	if err != nil {
+		return err
+	}
+	_, err = app.SendMessagef(ctx, d.rup, "Бот перезапускается. <b>%s</b> добавлено в очередь, загрузка начнется автоматически после перезапуска.", html.EscapeString(job.Title))
+	return err
+}
+
+func (d *dialog) pushToQueue(ctx context.Context, job app.QueuedDownload) (app.QueuedDownload, int, error) {
+	if job.Title == "" && job.Request != nil {
+		job.Title = d.titleOf(ctx, *job.Request)
+	}
+	user := d.rup.User()
+	job.UserID = user.ID
+	job.UserName = user.UserName
+	job.ChatID = d.rup.ChatID()
+	position, err := d.queue.Push(ctx, job)
+	if err != nil {
+		return job, 0, fmt.Errorf("failed to push download to queue: %w", err)
+	}
+	logging.FromContextS(ctx).Infof("Download %q is put to queue at position %d", job.Title, position)
+	return job, position, nil
+}
+
+// runQueue runs the job and then jobs from the user's queue one by one until the queue is empty.
+// done is called when runQueue is finished.
+func (d *dialog) runQueue(msgCtx context.Context, job app.QueuedDownload, done func()) {
+	ctx := logging.CopyContext(msgCtx, context.Background())
+	log := logging.FromContextS(ctx)
+	defer done()
+	metrics.ActiveJobs.Inc()
+	defer metrics.ActiveJobs.Dec()
+	userID := d.rup.User().ID
+	defer func() {
+		if err := d.jobStorage.DeleteActiveJob(ctx, userID); err != nil {
+			log.Errorf("Failed to delete active job from storage: %v", err)
+		}
+		d.statusMx.Lock()
+		d.isDownloadInProgress = false
+		leftDialog := d.stopped || d.interrupted
+		d.statusMx.Unlock()
+		if !leftDialog {
+			_, _ = d.rup.RedirectToDialog(ctx, app.DialogMain)
+		}
+	}()
+	for {
+		d.saveActiveJob(ctx, job)
+		// unfinished is true when the job isn't done completely and should be continued after restart.
+		var unfinished bool
+		if job.Batch != nil {
+			stoppedAt := d.downloadBatch(ctx, *job.Batch)
+			rest := *job.Batch
+			rest.Items = rest.Items[stoppedAt:]
+			job.Batch = &rest
+			unfinished = len(rest.Items) > 0
+		} else if job.Request != nil {
+			unfinished = d.downloadSingle(ctx, *job.Request) != nil
+		}
+		if d.isInterrupted() {
+			if unfinished {
+				d.requeueInterrupted(ctx, job)
+			}
+			return
+		}
+		d.statusMx.Lock()
+		stopped := d.stopped
+		d.statusMx.Unlock()
+		if stopped {
+			return
+		}
+		if d.jobs.Closed() {
+			// The rest of downloads stay in the queue and are continued after restart.
+			log.Info("Bot is shutting down, next download from queue isn't started")
+			return
+		}
+		var (
+			ok  bool
+			err error
+		)
+		job, ok, err = d.queue.Pop(ctx, userID)
+		if err != nil {
+			log.Errorf("Failed to pop download from queue: %v", err)
+			_, _ = app.SendMessagef(ctx, d.rup, "Не удалось получить следующую загрузку из очереди. Отправьте ссылку еще раз.")
+			return
+		}
+		if !ok {
+			return
+		}
+		log.Infof("Starting next download %q from queue", job.Title)
+		if err := d.sendMsgf(ctx, "Начинается следующая загрузка из очереди: <b>%s</b>", html.EscapeString(job.Title)); err != nil {
+			log.Errorf("Failed to notify user about next download: %v", err)
+		}
+	}
+}
+
+// saveActiveJob saves the running job, so it can be restarted if bot crashes.
+func (d *dialog) saveActiveJob(ctx context.Context, job app.QueuedDownload) {
+	user := d.rup.User()
+	job.UserID = user.ID
+	job.UserName = user.UserName
+	job.ChatID = d.rup.ChatID()
+	if job.Title == "" && job.Request != nil {
+		job.Title = job.Request.Link
+	}
+	if err := d.jobStorage.SaveActiveJob(ctx, job); err != nil {
+		logging.FromContextS(ctx).Errorf("Failed to save active job to storage: %v", err)
+	}
+}
+
+// requeueInterrupted puts the job interrupted by shutdown to the beginning of the queue and notifies user.
+func (d *dialog) requeueInterrupted(ctx context.Context, job app.QueuedDownload) {
+	log := logging.FromContextS(ctx)
+	user := d.rup.User()
+	job.UserID = user.ID
+	job.UserName = user.UserName
+	job.ChatID = d.rup.ChatID()
+	if err := d.queue.PushFront(ctx, job); err != nil {
+		log.Errorf("Failed to put interrupted download %q back to queue: %v", job.Title, err)
+		_, _ = app.SendMessagef(ctx, d.rup, "Бот перезапускается. Загрузка <b>%s</b> прервана, отправьте ссылку еще раз после перезапуска.", html.EscapeString(job.Title))
+		return
+	}
+	log.Infof("Interrupted download %q is put back to queue", job.Title)
+	_, _ = app.SendMessagef(ctx, d.rup, "Бот перезапускается. Загрузка <b>%s</b> прервана и продолжится автоматически после перезапуска.", html.EscapeString(job.Title))
+}
+
+// showQueue sends the list of queued downloads with buttons to remove them.
+func (d *dialog) showQueue(ctx context.Context) error {
+	text, keyboard, err := d.queueList(ctx)
+	if err != nil {
+		return err
+	}
+	msgID, err := d.rup.SendMessageWithInlineKeyboardf(ctx, keyboard, "%s", text)
+	if err != nil {
+		return err
+	}
+	d.queueMsgID = msgID
+	d.messagesToDelete.addMessage(msgID)
+	return nil
+}
+
+// removeFromQueue removes the download from queue and updates the message with the list of queue.
+func (d *dialog) removeFromQueue(ctx context.Context, id string) error {
+	ok, err := d.queue.Remove(ctx, d.rup.User().ID, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove download from queue: %w", err)
+	}
+	if ok {
+		logging.FromContextS(ctx).Infof("Download %q is removed from queue", id)
+	}
+	text, keyboard, err := d.queueList(ctx)
+	if err != nil {
+		return err
+	}
+	return d.rup.EditMessagef(ctx, d.queueMsgID, keyboard, "%s", text)
+}
+
+// queueList returns the text and buttons of the message with the list of queue.
+func (d *dialog) queueList(ctx context.Context) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	queued, err := d.queue.List(ctx, d.rup.User().ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list queue: %w", err)
+	}
+	if len(queued) == 0 {
+		return "Ваша очередь загрузок пуста.", nil, nil
+	}
+	lines := make([]string, 0, len(queued))
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(queued))
+	for i, job := range queued {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, html.EscapeString(job.Title)))
+		btn := fmt.Sprintf("❌ %d. %s", i+1, truncate(job.Title, maxQueueBtnTitleLen))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btn, cbRemoveQueuePrefix+job.ID)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	text := fmt.Sprintf("Ваша очередь загрузок:\n%s\n\nЧтобы удалить загрузку из очереди, нажмите на соответствующую кнопку.", strings.Join(lines, "\n"))
+	return text, &keyboard, nil
+}
+
+// titleOf returns the title of the video to show it in the queue. The link is returned if title can't be got.
+func (d *dialog) titleOf(ctx context.Context, req app.DownloadRequest) string {
+	info, err := d.downloadService.GetVideoInfo(ctx, req.Link)
+	if err != nil {
+		logging.FromContextS(ctx).Warnf("Failed to get title of video for queue: %v", err)
+		return req.Link
+	}
+	return info.Title
+}
+
+func truncate(s string, maxLen int) string {
+	r := []rune(s)
+	if len(r) <= maxLen {
+		return s
+	}
+	return string(r[:maxLen-1]) + "…"
+}
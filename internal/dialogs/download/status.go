@@ -0,0 +1,117 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/dialogs/progress"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+// progressUpdateInterval is the period of progress message updates. Telegram limits the rate of
+// messages sent to one chat by about one per second, and file uploads need this limit too.
+const progressUpdateInterval = 3 * time.Second
+
+const progressBarWidth = 12
+
+// startProgressUpdates edits the progress message with the current status of download periodically.
+// The returned function stops updates and waits until the last edit is finished.
+func (d *dialog) startProgressUpdates(ctx context.Context) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.updateProgress(ctx)
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func (d *dialog) updateProgress(ctx context.Context) {
+	log := logging.FromContextS(ctx)
+	ticker := time.NewTicker(progressUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		d.statusMx.Lock()
+		msgID, text, shown := d.progressMsgID, d.progressText, d.progressShown
+		d.statusMx.Unlock()
+		if msgID == 0 {
+			continue
+		}
+		newShown := d.renderProgress(ctx, text)
+		if newShown == shown {
+			continue
+		}
+		err := d.rup.EditMessagef(ctx, msgID, &progressKeyboard, "%s", newShown)
+		var rateLimitErr *app.RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			log.Warnf("Progress message updates are paused for %v by rate limit of Telegram", rateLimitErr.RetryAfter)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rateLimitErr.RetryAfter):
+			}
+			continue
+		}
+		if err != nil {
+			log.Warnf("Failed to update progress message: %v", err)
+			continue
+		}
+		d.statusMx.Lock()
+		if d.progressMsgID == msgID {
+			d.progressShown = newShown
+		}
+		d.statusMx.Unlock()
+	}
+}
+
+// renderProgress returns the text of progress message with the current status of download.
+func (d *dialog) renderProgress(ctx context.Context, text string) string {
+	return text + "\n\n" + d.statusText(ctx)
+}
+
+// statusText describes the progress of current download.
+func (d *dialog) statusText(ctx context.Context) string {
+	if position := d.scheduler.Position(d.rup.User().ID); position > 0 {
+		return fmt.Sprintf("⏳ Ваша загрузка ожидает в очереди, позиция: <b>%d</b>.", position)
+	}
+	d.statusMx.Lock()
+	status := d.status
+	d.statusMx.Unlock()
+	if status == nil || status.progressCounter == nil {
+		return "Загрузка еще не началась, подождите немного..."
+	}
+	pc := status.progressCounter
+	contentLen := pc.ContentLen()
+	currentDownloadedMB := bytesToMegabytes(pc.CurrentDownloaded())
+	speed := pc.Speed()
+	// Media from cache isn't downloaded from YouTube, only the speed of reading is known then.
+	if sc := status.sourceCounter; sc != nil && sc.CurrentDownloaded() > 0 {
+		speed = sc.Speed()
+	}
+	speedMB := bytesToMegabytes(int64(speed))
+	if contentLen == 0 {
+		return fmt.Sprintf("Загружено: <i>%.2fMB</i>\nСкорость: <b>%.2fMB/s</b>\nОпределить прогресс в процентах для данного видео невозможно...", currentDownloadedMB, speedMB)
+	}
+	estimatedTimeS := "???"
+	if estimatedTime, err := pc.EstimatedTime(); err == nil && estimatedTime >= 0 {
+		estimatedTimeS = estimatedTime.Round(time.Second).String()
+	}
+	// Counter of the clip may exceed the estimated content length.
+	percentage := math.Min(pc.Percentage(), 100)
+	return fmt.Sprintf("<code>%s</code> <b>%.1f%%</b>\nЗагружено: <i>%.2fMB</i> из <i>%.2fMB</i>\nСкорость: <b>%.2fMB/s</b>\nОсталось: <b>%s</b>",
+		progress.Bar(percentage, progressBarWidth), percentage, currentDownloadedMB, bytesToMegabytes(contentLen), speedMB, estimatedTimeS)
+}
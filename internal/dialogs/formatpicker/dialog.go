@@ -0,0 +1,386 @@
+package formatpicker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/downloader"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+const (
+	btnCancel         = "Отмена"
+	btnBack           = "« Назад"
+	btnDefaultProfile = "По умолчанию"
+	btnClip           = "✂️ Вырезать фрагмент"
+	btnSplitChapters  = "📑 Разбить аудио по главам"
+	btnWholeAudio     = "📑 Не разбивать аудио по главам"
+	btnPlaylist       = "📃 Скачать треки плейлиста"
+)
+
+// Callback data of inline buttons.
+const (
+	cbFormatPrefix  = "f:"
+	cbProfilePrefix = "p:"
+	cbClip          = "clip"
+	cbChapters      = "chapters"
+	cbPlaylist      = "playlist"
+	cbBack          = "back"
+	cbCancel        = "cancel"
+)
+
+const oneMB = 1048576
+
+// dialog shows formats available for the video and lets user choose one of them by inline buttons.
+type dialog struct {
+	rup             app.ReqUserProvider
+	downloadService app.DownloadService
+	settings        *app.UserSettingsStore
+
+	link         string
+	title        string
+	duration     time.Duration
+	clip         app.TimeRange
+	formats      []app.FormatInfo
+	formatByItag map[int]app.FormatInfo
+	// msgID is the id of message with buttons of formats.
+	msgID int
+
+	chapters        []app.Chapter
+	splitByChapters bool
+
+	// audioRequest is the request waiting for the audio profile to be chosen.
+	audioRequest *app.DownloadRequest
+}
+
+func New(rup app.ReqUserProvider, downloadService app.DownloadService, settings *app.UserSettingsStore) app.Dialog {
+	return &dialog{
+		rup:             rup,
+		downloadService: downloadService,
+		settings:        settings,
+	}
+}
+
+func (d *dialog) OnEnter(ctx context.Context) error {
+	log := logging.FromContextS(ctx)
+	log.Info("User entered to format picker dialog")
+	return nil
+}
+
+func (d *dialog) OnMessage(ctx context.Context, text string, msgID int) error {
+	if d.link == "" {
+		return d.showFormats(ctx, text)
+	}
+	if downloader.IsPlaylistLink(text) {
+		if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s", d.headerText()); err != nil {
+			return err
+		}
+		playlistDlg, err := d.rup.RedirectToDialog(ctx, app.DialogPlaylist)
+		if err != nil {
+			return err
+		}
+		return playlistDlg.OnMessage(ctx, text, msgID)
+	}
+	if err := downloader.ValidateLink(text); err == nil {
+		return d.showFormats(ctx, text)
+	}
+	if clip, err := downloader.ParseTimeRange(text); err == nil {
+		return d.setClip(ctx, clip)
+	}
+	return app.NewUserError("Выберите формат с помощью кнопок под сообщением или нажмите «Отмена».")
+}
+
+func (d *dialog) OnCallback(ctx context.Context, data string, msgID int) error {
+	if d.msgID == 0 || msgID != d.msgID {
+		return app.NewInactiveButtonError()
+	}
+	log := logging.FromContextS(ctx)
+	switch {
+	case data == cbCancel:
+		if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s\n\nВыбор формата отменен.", d.headerText()); err != nil {
+			return err
+		}
+		_, err := d.rup.RedirectToDialog(ctx, app.DialogMain)
+		return err
+	case data == cbClip:
+		_, err := app.SendMessagef(ctx, d.rup, "Отправьте временной диапазон фрагмента, например <code>1:20-4:05</code> или <code>с 1:20 до 4:05</code>.")
+		return err
+	case data == cbChapters && len(d.chapters) > 0:
+		d.splitByChapters = !d.splitByChapters
+		return d.editFormats(ctx)
+	case data == cbPlaylist && d.inPlaylist():
+		if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s\n\nВыбрано: <i>%s</i>", d.headerText(), btnPlaylist); err != nil {
+			return err
+		}
+		playlistDlg, err := d.rup.RedirectToDialog(ctx, app.DialogPlaylist)
+		if err != nil {
+			return err
+		}
+		return playlistDlg.OnMessage(ctx, d.link, msgID)
+	case data == cbBack:
+		d.audioRequest = nil
+		return d.editFormats(ctx)
+	case strings.HasPrefix(data, cbFormatPrefix):
+		itag, err := strconv.Atoi(strings.TrimPrefix(data, cbFormatPrefix))
+		if err != nil {
+			return fmt.Errorf("invalid itag in callback data %q: %w", data, err)
+		}
+		f, ok := d.formatByItag[itag]
+		if !ok {
+			return app.NewInactiveButtonError()
+		}
+		log.Infof("User chose format with itag=%d", itag)
+		req := app.DownloadRequest{
+			Link:    d.link,
+			Kind:    f.Kind,
+			Options: app.DownloadOptions{Itag: f.Itag},
+		}
+		if req.Kind == app.MediaAudio {
+			return d.showAudioProfiles(ctx, req)
+		}
+		return d.startDownloading(ctx, req, formatButtonText(f))
+	case strings.HasPrefix(data, cbProfilePrefix) && d.audioRequest != nil:
+		profileName := strings.TrimPrefix(data, cbProfilePrefix)
+		profileTitle := btnDefaultProfile
+		if profileName == "" {
+			profileName = d.userAudioProfile()
+		}
+		if profileName != "" {
+			profile, err := downloader.AudioProfileByName(profileName)
+			if err != nil {
+				return fmt.Errorf("invalid profile in callback data: %w", err)
+			}
+			profileTitle = profile.Title
+		}
+		req := *d.audioRequest
+		req.Options.AudioProfile = profileName
+		log.Infof("User chose audio profile %q", profileName)
+		return d.startDownloading(ctx, req, fmt.Sprintf("%s, %s", formatButtonText(d.formatByItag[req.Options.Itag]), profileTitle))
+	}
+	return app.NewInactiveButtonError()
+}
+
+func (d *dialog) setClip(ctx context.Context, clip app.TimeRange) error {
+	if d.duration > 0 && clip.Start >= d.duration {
+		return app.NewUserError(fmt.Sprintf("Начало фрагмента за пределами видео. Длительность видео: %s.", app.FormatTimestamp(d.duration)))
+	}
+	if d.duration > 0 && clip.End > d.duration {
+		clip.End = 0
+	}
+	d.clip = clip
+	logging.FromContextS(ctx).Infof("User set clip %s", clip)
+	if d.audioRequest != nil {
+		if err := d.rup.EditMessagef(ctx, d.msgID, d.profilesKeyboard(), "%s\n\nВыберите кодек и качество аудиофайла.", d.headerText()); err != nil {
+			return err
+		}
+	} else if err := d.editFormats(ctx); err != nil {
+		return err
+	}
+	_, err := app.SendMessagef(ctx, d.rup, "Будет скачан только фрагмент <b>%s</b>. Выберите формат с помощью кнопок выше.", clip)
+	return err
+}
+
+func (d *dialog) startDownloading(ctx context.Context, req app.DownloadRequest, formatText string) error {
+	req.Options.Clip = d.clip
+	req.Options.SplitByChapters = d.splitByChapters && req.Kind == app.MediaAudio
+	if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s\n\nВыбран формат: <i>%s</i>", d.headerText(), html.EscapeString(formatText)); err != nil {
+		return err
+	}
+	dlg, err := d.rup.RedirectToDialog(ctx, app.DialogYoutubeDownload)
+	if err != nil {
+		return err
+	}
+	downloadDlg, ok := dlg.(app.DownloadDialog)
+	if !ok {
+		return fmt.Errorf("dialog %T can't start downloading", dlg)
+	}
+	return downloadDlg.StartDownloading(ctx, req)
+}
+
+func (d *dialog) showFormats(ctx context.Context, link string) error {
+	info, err := d.downloadService.GetVideoInfo(ctx, link)
+	if err != nil {
+		return app.NewUserError("Не удалось получить информацию о видео. Проверьте ссылку или повторите попытку позже.").WithCause(err)
+	}
+	if len(info.Formats) == 0 {
+		return app.NewUserError("У данного видео нет доступных для скачивания форматов.")
+	}
+	d.link = link
+	d.title = info.Title
+	d.duration = info.Duration
+	d.clip = app.TimeRange{}
+	if start, ok := downloader.LinkStartTime(link); ok && start < info.Duration {
+		d.clip.Start = start
+	}
+	d.chapters = info.Chapters
+	d.splitByChapters = false
+	d.audioRequest = nil
+	d.formats = info.Formats
+	d.formatByItag = make(map[int]app.FormatInfo, len(info.Formats))
+	for _, f := range info.Formats {
+		d.formatByItag[f.Itag] = f
+	}
+
+	d.msgID, err = d.rup.SendMessageWithInlineKeyboardf(ctx, d.formatsKeyboard(), "%s", d.formatsText())
+	return err
+}
+
+func (d *dialog) editFormats(ctx context.Context) error {
+	return d.rup.EditMessagef(ctx, d.msgID, d.formatsKeyboard(), "%s", d.formatsText())
+}
+
+// headerText describes the video and chosen options.
+func (d *dialog) headerText() string {
+	text := fmt.Sprintf("<b>%s</b>\nДлительность: <i>%s</i>", html.EscapeString(d.title), d.duration.Round(time.Second))
+	if !d.clip.IsZero() {
+		text += fmt.Sprintf("\nФрагмент: <i>%s</i>", d.clip)
+	}
+	if len(d.chapters) > 0 {
+		text += fmt.Sprintf("\nГлав в видео: <i>%d</i>", len(d.chapters))
+		if d.splitByChapters {
+			text += fmt.Sprintf("\nАудио будет разбито на <b>%d</b> файлов по главам видео.", len(d.chapters))
+		}
+	}
+	return text
+}
+
+func (d *dialog) formatsText() string {
+	return d.headerText() + "\n\nВыберите формат для скачивания. 🎵 — аудио, 🎬 — видео. Размер указан для всего видео приблизительно."
+}
+
+func (d *dialog) formatsKeyboard() *tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(d.formats)+4)
+	for _, f := range d.formats {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(formatButtonText(f), cbFormatPrefix+strconv.Itoa(f.Itag)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnClip, cbClip)))
+	if len(d.chapters) > 0 {
+		btn := btnSplitChapters
+		if d.splitByChapters {
+			btn = btnWholeAudio
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btn, cbChapters)))
+	}
+	if d.inPlaylist() {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnPlaylist, cbPlaylist)))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnCancel, cbCancel)))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &keyboard
+}
+
+// inPlaylist returns true when the video is opened within the playlist, so tracks of the playlist can be downloaded instead.
+func (d *dialog) inPlaylist() bool {
+	_, err := downloader.ExtractPlaylistID(d.link)
+	return err == nil
+}
+
+// showAudioProfiles asks user how the chosen audio format should be transcoded.
+func (d *dialog) showAudioProfiles(ctx context.Context, req app.DownloadRequest) error {
+	d.audioRequest = &req
+	return d.rup.EditMessagef(ctx, d.msgID, d.profilesKeyboard(), "%s\n\nВыберите кодек и качество аудиофайла.", d.headerText())
+}
+
+// userAudioProfile returns the audio profile chosen by user in settings if it supports the chosen format.
+func (d *dialog) userAudioProfile() string {
+	name := d.settings.Get(d.rup.User().ID).AudioProfile
+	if name == "" {
+		return ""
+	}
+	profile, err := downloader.AudioProfileByName(name)
+	if err != nil || !profile.SupportsSource(d.formatByItag[d.audioRequest.Options.Itag].MimeType) {
+		return ""
+	}
+	return name
+}
+
+// profilesKeyboard returns buttons of audio profiles. Profiles which can't handle the source format aren't shown.
+func (d *dialog) profilesKeyboard() *tgbotapi.InlineKeyboardMarkup {
+	sourceMime := d.formatByItag[d.audioRequest.Options.Itag].MimeType
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnDefaultProfile, cbProfilePrefix)),
+	}
+	for _, p := range downloader.AudioProfiles() {
+		if !p.SupportsSource(sourceMime) {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(p.Title, cbProfilePrefix+p.Name)))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(btnBack, cbBack),
+		tgbotapi.NewInlineKeyboardButtonData(btnCancel, cbCancel),
+	))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &keyboard
+}
+
+// formatButtonText returns text of the button for the format.
+func formatButtonText(f app.FormatInfo) string {
+	codec := strings.SplitN(f.Codec, ".", 2)[0]
+	sizeMB := float64(f.EstimatedSize) / oneMB
+	if f.Kind == app.MediaVideo {
+		withAudio := ""
+		if f.WithAudio {
+			withAudio = " 🔊"
+		}
+		return fmt.Sprintf("🎬 %s · %s%s · ~%.1fMB", f.QualityLabel, codec, withAudio, sizeMB)
+	}
+	return fmt.Sprintf("🎵 %s · %dkbps · ~%.1fMB", codec, f.Bitrate/1000, sizeMB)
+}
+
+// state is the part of dialog saved to storage.
+type state struct {
+	Link            string
+	Title           string
+	Duration        time.Duration
+	Clip            app.TimeRange
+	Formats         []app.FormatInfo
+	MsgID           int
+	Chapters        []app.Chapter
+	SplitByChapters bool
+	AudioRequest    *app.DownloadRequest
+}
+
+func (d *dialog) MarshalState() ([]byte, error) {
+	return json.Marshal(state{
+		Link:            d.link,
+		Title:           d.title,
+		Duration:        d.duration,
+		Clip:            d.clip,
+		Formats:         d.formats,
+		MsgID:           d.msgID,
+		Chapters:        d.chapters,
+		SplitByChapters: d.splitByChapters,
+		AudioRequest:    d.audioRequest,
+	})
+}
+
+func (d *dialog) RestoreState(data []byte) error {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to unmarshal state of format picker: %w", err)
+	}
+	d.link = s.Link
+	d.title = s.Title
+	d.duration = s.Duration
+	d.clip = s.Clip
+	d.formats = s.Formats
+	d.msgID = s.MsgID
+	d.chapters = s.Chapters
+	d.splitByChapters = s.SplitByChapters
+	d.audioRequest = s.AudioRequest
+	d.formatByItag = make(map[int]app.FormatInfo, len(s.Formats))
+	for _, f := range s.Formats {
+		d.formatByItag[f.Itag] = f
+	}
+	return nil
+}
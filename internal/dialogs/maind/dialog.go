@@ -22,17 +22,29 @@ func (d *dialog) OnEnter(ctx context.Context) error {
 }
 
 func (d *dialog) OnMessage(ctx context.Context, text string, msgID int) error {
+	// Links to the video opened within the playlist are handled as video, the playlist is offered by format picker.
+	if downloader.IsPlaylistLink(text) {
+		playlistDlg, err := d.rup.RedirectToDialog(ctx, app.DialogPlaylist)
+		if err != nil {
+			return err
+		}
+		return playlistDlg.OnMessage(ctx, text, msgID)
+	}
 	if err := downloader.ValidateLink(text); err != nil {
 		return app.
-			NewUserError("Введите корректную ссылку на любой YouTube-ролик, чтобы получить аудиозапись").
+			NewUserError("Введите корректную ссылку на любой YouTube-ролик или плейлист, чтобы получить аудиозапись или видео").
 			WithCause(err)
 	}
-	downloadDlg, err := d.rup.RedirectToDialog(ctx, app.DialogYoutubeDownload)
+	pickerDlg, err := d.rup.RedirectToDialog(ctx, app.DialogFormatPicker)
 	if err != nil {
 		return err
 	}
-	if err := downloadDlg.OnMessage(ctx, text, msgID); err != nil {
+	if err := pickerDlg.OnMessage(ctx, text, msgID); err != nil {
 		return err
 	}
 	return nil
 }
+
+func (d *dialog) OnCallback(ctx context.Context, data string, msgID int) error {
+	return app.NewInactiveButtonError()
+}
@@ -0,0 +1,333 @@
+package playlist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/downloader"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+const (
+	btnCancel           = "Отмена"
+	btnDownloadAll      = "Скачать все треки"
+	btnDownloadSelected = "Скачать выбранные треки"
+	btnSelectTracks     = "Выбрать треки"
+	btnOnlyVideo        = "Скачать только это видео"
+	btnPrevPage         = "« Назад"
+	btnNextPage         = "Далее »"
+)
+
+const (
+	// tracksPerPage limits the number of tracks listed in one message, so it doesn't exceed the limit of Telegram.
+	tracksPerPage = 20
+	// maxTrackTitleLen limits the length of track title in the list.
+	maxTrackTitleLen = 80
+)
+
+// Callback data of inline buttons.
+const (
+	cbDownloadAll      = "all"
+	cbDownloadSelected = "selected"
+	cbSelectTracks     = "select"
+	cbOnlyVideo        = "video"
+	cbCancel           = "cancel"
+	cbPagePrefix       = "page:"
+)
+
+// dialog shows tracks of the playlist and lets user choose which of them should be downloaded as audio
+// by inline buttons.
+type dialog struct {
+	rup             app.ReqUserProvider
+	downloadService app.DownloadService
+
+	link     string
+	info     app.PlaylistInfo
+	selected []int
+	// page is the shown page of the track list.
+	page int
+	// msgID is the id of message with buttons of playlist.
+	msgID int
+}
+
+func New(rup app.ReqUserProvider, downloadService app.DownloadService) app.Dialog {
+	return &dialog{
+		rup:             rup,
+		downloadService: downloadService,
+	}
+}
+
+func (d *dialog) OnEnter(ctx context.Context) error {
+	log := logging.FromContextS(ctx)
+	log.Info("User entered to playlist dialog")
+	return nil
+}
+
+func (d *dialog) OnMessage(ctx context.Context, text string, msgID int) error {
+	if d.link == "" {
+		return d.showPlaylist(ctx, text)
+	}
+	if downloader.IsPlaylistLink(text) {
+		return d.showPlaylist(ctx, text)
+	}
+	if err := downloader.ValidateLink(text); err == nil {
+		return d.leave(ctx, text, msgID)
+	}
+	selected, err := downloader.ParseTrackSelection(text, len(d.info.Entries))
+	if err != nil {
+		return app.
+			NewUserError(fmt.Sprintf("Не удалось разобрать номера треков. Отправьте номера от 1 до %d, например <code>1-10</code> или <code>1, 3, 5-7</code>, или воспользуйтесь кнопками под сообщением с плейлистом.", len(d.info.Entries))).
+			WithCause(err)
+	}
+	return d.setSelected(ctx, selected)
+}
+
+func (d *dialog) OnCallback(ctx context.Context, data string, msgID int) error {
+	if d.msgID == 0 || msgID != d.msgID {
+		return app.NewInactiveButtonError()
+	}
+	switch {
+	case data == cbCancel:
+		if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s\n\nСкачивание плейлиста отменено.", d.headerText()); err != nil {
+			return err
+		}
+		_, err := d.rup.RedirectToDialog(ctx, app.DialogMain)
+		return err
+	case data == cbOnlyVideo && d.hasVideo():
+		if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s\n\nВыбрано: <i>%s</i>", d.headerText(), btnOnlyVideo); err != nil {
+			return err
+		}
+		pickerDlg, err := d.rup.RedirectToDialog(ctx, app.DialogFormatPicker)
+		if err != nil {
+			return err
+		}
+		return pickerDlg.OnMessage(ctx, d.link, msgID)
+	case data == cbDownloadAll:
+		all := make([]int, len(d.info.Entries))
+		for i := range all {
+			all[i] = i
+		}
+		return d.startDownloading(ctx, all)
+	case data == cbDownloadSelected && len(d.selected) > 0:
+		return d.startDownloading(ctx, d.selected)
+	case strings.HasPrefix(data, cbPagePrefix):
+		page, err := strconv.Atoi(strings.TrimPrefix(data, cbPagePrefix))
+		if err != nil {
+			return fmt.Errorf("invalid page in callback data %q: %w", data, err)
+		}
+		if page < 0 || page >= d.pagesCount() {
+			return app.NewInactiveButtonError()
+		}
+		d.page = page
+		return d.rup.EditMessagef(ctx, d.msgID, d.playlistKeyboard(), "%s", d.playlistText())
+	case data == cbSelectTracks:
+		_, err := app.SendMessagef(ctx, d.rup, "Отправьте номера треков от 1 до %d, например <code>1-10</code> или <code>1, 3, 5-7</code>.", len(d.info.Entries))
+		return err
+	}
+	return app.NewInactiveButtonError()
+}
+
+// leave removes buttons of the playlist and handles the link to video in main dialog.
+func (d *dialog) leave(ctx context.Context, link string, msgID int) error {
+	if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s", d.headerText()); err != nil {
+		return err
+	}
+	mainDlg, err := d.rup.RedirectToDialog(ctx, app.DialogMain)
+	if err != nil {
+		return err
+	}
+	return mainDlg.OnMessage(ctx, link, msgID)
+}
+
+func (d *dialog) showPlaylist(ctx context.Context, link string) error {
+	info, err := d.downloadService.GetPlaylistInfo(ctx, link)
+	if err != nil {
+		return app.NewUserError("Не удалось получить информацию о плейлисте. Проверьте ссылку или повторите попытку позже.").WithCause(err)
+	}
+	if len(info.Entries) == 0 {
+		return app.NewUserError("В данном плейлисте нет доступных для скачивания видео.")
+	}
+	if d.msgID != 0 {
+		// Buttons of the previous playlist aren't active anymore.
+		if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s", d.headerText()); err != nil {
+			logging.FromContextS(ctx).Warnf("Failed to remove buttons of previous playlist: %v", err)
+		}
+	}
+	d.link = link
+	d.info = info
+	d.selected = nil
+	d.page = 0
+
+	d.msgID, err = d.rup.SendMessageWithInlineKeyboardf(ctx, d.playlistKeyboard(), "%s", d.playlistText())
+	return err
+}
+
+func (d *dialog) setSelected(ctx context.Context, selected []int) error {
+	d.selected = selected
+	logging.FromContextS(ctx).Infof("User selected %d tracks of playlist", len(selected))
+	if err := d.rup.EditMessagef(ctx, d.msgID, d.playlistKeyboard(), "%s", d.playlistText()); err != nil {
+		return err
+	}
+	_, err := app.SendMessagef(ctx, d.rup, "Выбрано треков: <b>%d</b>. Нажмите «%s» под сообщением с плейлистом, чтобы начать загрузку, или отправьте другие номера.", len(selected), btnDownloadSelected)
+	return err
+}
+
+// headerText describes the playlist.
+func (d *dialog) headerText() string {
+	text := fmt.Sprintf("<b>%s</b>\n", html.EscapeString(d.info.Title))
+	if d.info.Author != "" {
+		text += fmt.Sprintf("Автор: <i>%s</i>\n", html.EscapeString(d.info.Author))
+	}
+	text += fmt.Sprintf("Треков: <i>%d</i>\nОбщая длительность: <i>%s</i>", len(d.info.Entries), d.info.TotalDuration().Round(time.Second))
+	return text
+}
+
+func (d *dialog) playlistText() string {
+	text := d.headerText() + "\n\n" + d.tracksText()
+	if len(d.selected) > 0 {
+		var duration time.Duration
+		for _, i := range d.selected {
+			duration += d.info.Entries[i].Duration
+		}
+		text += fmt.Sprintf("\n\nВыбрано треков: <b>%d</b>\nДлительность выбранных треков: <i>%s</i>", len(d.selected), duration.Round(time.Second))
+	}
+	return text + "\n\nСкачать аудио всех треков плейлиста? Вы также можете выбрать только нужные треки, отправив их номера."
+}
+
+// tracksText lists tracks of the current page. Selected tracks are marked.
+func (d *dialog) tracksText() string {
+	selected := make(map[int]struct{}, len(d.selected))
+	for _, i := range d.selected {
+		selected[i] = struct{}{}
+	}
+	text := &strings.Builder{}
+	start := d.page * tracksPerPage
+	end := min(start+tracksPerPage, len(d.info.Entries))
+	for i := start; i < end; i++ {
+		entry := d.info.Entries[i]
+		mark := ""
+		if _, ok := selected[i]; ok {
+			mark = "✅ "
+		}
+		_, _ = fmt.Fprintf(text, "%s%d. %s <i>(%s)</i>\n", mark, i+1, html.EscapeString(truncate(entry.Title, maxTrackTitleLen)), app.FormatTimestamp(entry.Duration))
+	}
+	if pages := d.pagesCount(); pages > 1 {
+		_, _ = fmt.Fprintf(text, "\nСтраница %d из %d", d.page+1, pages)
+	}
+	return strings.TrimSuffix(text.String(), "\n")
+}
+
+func (d *dialog) pagesCount() int {
+	return (len(d.info.Entries) + tracksPerPage - 1) / tracksPerPage
+}
+
+// hasVideo returns true when the link of playlist is opened on the certain video, so it can be downloaded alone.
+func (d *dialog) hasVideo() bool {
+	return !downloader.IsPlaylistLink(d.link)
+}
+
+func (d *dialog) playlistKeyboard() *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(d.selected) > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnDownloadSelected, cbDownloadSelected)))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnDownloadAll, cbDownloadAll)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnSelectTracks, cbSelectTracks)),
+	)
+	if pages := d.pagesCount(); pages > 1 {
+		var nav []tgbotapi.InlineKeyboardButton
+		if d.page > 0 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(btnPrevPage, cbPagePrefix+strconv.Itoa(d.page-1)))
+		}
+		if d.page < pages-1 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(btnNextPage, cbPagePrefix+strconv.Itoa(d.page+1)))
+		}
+		rows = append(rows, nav)
+	}
+	if d.hasVideo() {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnOnlyVideo, cbOnlyVideo)))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnCancel, cbCancel)))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &keyboard
+}
+
+// startDownloading downloads audio of the entries with specified indexes.
+func (d *dialog) startDownloading(ctx context.Context, indexes []int) error {
+	if err := d.rup.EditMessagef(ctx, d.msgID, nil, "%s\n\nБудет скачано треков: <b>%d</b>", d.headerText(), len(indexes)); err != nil {
+		return err
+	}
+	batch := app.DownloadBatch{
+		Title: d.info.Title,
+		Items: make([]app.BatchItem, 0, len(indexes)),
+	}
+	for _, i := range indexes {
+		entry := d.info.Entries[i]
+		batch.Items = append(batch.Items, app.BatchItem{
+			Title: entry.Title,
+			Request: app.DownloadRequest{
+				Link:    entry.Link,
+				Kind:    app.MediaAudio,
+				Options: app.DownloadOptions{Album: d.info.Title},
+			},
+		})
+	}
+	logging.FromContextS(ctx).Infof("User started downloading of %d tracks of playlist", len(batch.Items))
+	dlg, err := d.rup.RedirectToDialog(ctx, app.DialogYoutubeDownload)
+	if err != nil {
+		return err
+	}
+	downloadDlg, ok := dlg.(app.DownloadDialog)
+	if !ok {
+		return fmt.Errorf("dialog %T can't start downloading", dlg)
+	}
+	return downloadDlg.StartBatchDownloading(ctx, batch)
+}
+
+// state is the part of dialog saved to storage.
+type state struct {
+	Link     string
+	Info     app.PlaylistInfo
+	Selected []int
+	Page     int
+	MsgID    int
+}
+
+func (d *dialog) MarshalState() ([]byte, error) {
+	return json.Marshal(state{
+		Link:     d.link,
+		Info:     d.info,
+		Selected: d.selected,
+		Page:     d.page,
+		MsgID:    d.msgID,
+	})
+}
+
+func (d *dialog) RestoreState(data []byte) error {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to unmarshal state of playlist: %w", err)
+	}
+	d.link = s.Link
+	d.info = s.Info
+	d.selected = s.Selected
+	d.page = s.Page
+	d.msgID = s.MsgID
+	return nil
+}
+
+func truncate(s string, maxLen int) string {
+	r := []rune(s)
+	if len(r) <= maxLen {
+		return s
+	}
+	return string(r[:maxLen-1]) + "…"
+}
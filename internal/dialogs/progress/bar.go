@@ -0,0 +1,23 @@
+package progress
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	barFilled = "█"
+	barEmpty  = "░"
+)
+
+// Bar renders the text progress bar of width cells, e.g. "██████░░░░" for 60%.
+func Bar(percentage float64, width int) string {
+	if math.IsNaN(percentage) || percentage < 0 {
+		percentage = 0
+	}
+	if percentage > 100 {
+		percentage = 100
+	}
+	filled := int(percentage / 100 * float64(width))
+	return strings.Repeat(barFilled, filled) + strings.Repeat(barEmpty, width-filled)
+}
@@ -0,0 +1,24 @@
+package progress
+
+import "testing"
+
+func TestBar(t *testing.T) {
+	tests := []struct {
+		name       string
+		percentage float64
+		want       string
+	}{
+		{name: "should_be_empty_on_zero", percentage: 0, want: "░░░░░░░░░░"},
+		{name: "should_round_down_partial_cell", percentage: 67.5, want: "██████░░░░"},
+		{name: "should_be_full_on_hundred", percentage: 100, want: "██████████"},
+		{name: "should_clamp_overflow", percentage: 120, want: "██████████"},
+		{name: "should_clamp_negative", percentage: -5, want: "░░░░░░░░░░"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Bar(tt.percentage, 10); got != tt.want {
+				t.Errorf("Bar(%v) = %q, want %q", tt.percentage, got, tt.want)
+			}
+		})
+	}
+}
@@ -21,6 +21,15 @@ func NewCounter(contentLen int64) *Counter {
 	}
 }
 
+// NewClipCounter creates counter for the clip of the media. The clip is only a part of the whole media,
+// so content length is scaled in proportion of clip duration to the total duration.
+func NewClipCounter(contentLen int64, totalDuration, clipDuration time.Duration) *Counter {
+	if totalDuration > 0 && clipDuration > 0 && clipDuration < totalDuration {
+		contentLen = int64(float64(contentLen) * (float64(clipDuration) / float64(totalDuration)))
+	}
+	return NewCounter(contentLen)
+}
+
 func (c *Counter) Write(p []byte) (n int, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -43,3 +52,14 @@ func (c *Counter) CurrentDownloaded() int64 {
 	defer c.mu.RUnlock()
 	return c.currentDownloaded
 }
+
+// Speed returns the average downloading speed in bytes per second.
+func (c *Counter) Speed() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	elapsed := time.Since(c.startTime).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(c.currentDownloaded) / elapsed
+}
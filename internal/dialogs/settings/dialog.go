@@ -0,0 +1,143 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/downloader"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+const (
+	btnDefaultProfile = "По умолчанию"
+	btnClose          = "Готово"
+	checkMark         = "✅ "
+)
+
+// Callback data of inline buttons.
+const (
+	cbProfilePrefix = "p:"
+	cbClose         = "close"
+)
+
+// dialog lets user change preferences by inline buttons.
+type dialog struct {
+	rup      app.ReqUserProvider
+	settings *app.UserSettingsStore
+
+	// msgID is the id of message with buttons of settings.
+	msgID int
+}
+
+func New(rup app.ReqUserProvider, settings *app.UserSettingsStore) app.Dialog {
+	return &dialog{
+		rup:      rup,
+		settings: settings,
+	}
+}
+
+func (d *dialog) OnEnter(ctx context.Context) error {
+	log := logging.FromContextS(ctx)
+	log.Info("User entered to settings dialog")
+	msgID, err := d.rup.SendMessageWithInlineKeyboardf(ctx, d.keyboard(), "%s", d.text())
+	if err != nil {
+		return err
+	}
+	d.msgID = msgID
+	return nil
+}
+
+// OnMessage leaves settings and handles the message in main dialog, so user can just send the link.
+func (d *dialog) OnMessage(ctx context.Context, text string, msgID int) error {
+	if err := d.close(ctx); err != nil {
+		return err
+	}
+	mainDlg, err := d.rup.RedirectToDialog(ctx, app.DialogMain)
+	if err != nil {
+		return err
+	}
+	return mainDlg.OnMessage(ctx, text, msgID)
+}
+
+func (d *dialog) OnCallback(ctx context.Context, data string, msgID int) error {
+	if msgID != d.msgID {
+		return app.NewInactiveButtonError()
+	}
+	switch {
+	case data == cbClose:
+		if err := d.close(ctx); err != nil {
+			return err
+		}
+		_, err := d.rup.RedirectToDialog(ctx, app.DialogMain)
+		return err
+	case strings.HasPrefix(data, cbProfilePrefix):
+		profileName := strings.TrimPrefix(data, cbProfilePrefix)
+		if profileName != "" {
+			if _, err := downloader.AudioProfileByName(profileName); err != nil {
+				return fmt.Errorf("invalid profile in callback data: %w", err)
+			}
+		}
+		userID := d.rup.User().ID
+		settings := d.settings.Get(userID)
+		settings.AudioProfile = profileName
+		d.settings.Set(userID, settings)
+		logging.FromContextS(ctx).Infof("User changed audio profile to %q", profileName)
+		return d.rup.EditMessagef(ctx, d.msgID, d.keyboard(), "%s", d.text())
+	}
+	return app.NewInactiveButtonError()
+}
+
+// close removes buttons from the message with settings.
+func (d *dialog) close(ctx context.Context) error {
+	return d.rup.EditMessagef(ctx, d.msgID, nil, "%s\n\nНастройки сохранены.", d.text())
+}
+
+func (d *dialog) text() string {
+	profileTitle := btnDefaultProfile
+	if profile, err := downloader.AudioProfileByName(d.settings.Get(d.rup.User().ID).AudioProfile); err == nil {
+		profileTitle = profile.Title
+	}
+	return fmt.Sprintf("<b>Настройки</b>\n\nФормат аудио: <b>%s</b>\n\nВыбранный формат используется, если вы не указали его при загрузке, например для плейлистов.", profileTitle)
+}
+
+// keyboard returns buttons of audio profiles which can be used without choosing the source format.
+func (d *dialog) keyboard() *tgbotapi.InlineKeyboardMarkup {
+	current := d.settings.Get(d.rup.User().ID).AudioProfile
+	button := func(title, name string) []tgbotapi.InlineKeyboardButton {
+		if name == current {
+			title = checkMark + title
+		}
+		return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(title, cbProfilePrefix+name))
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{button(btnDefaultProfile, "")}
+	for _, p := range downloader.AudioProfiles() {
+		if p.SupportsDefaultSource() {
+			rows = append(rows, button(p.Title, p.Name))
+		}
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnClose, cbClose)))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &keyboard
+}
+
+// state is the part of dialog saved to storage.
+type state struct {
+	MsgID int
+}
+
+func (d *dialog) MarshalState() ([]byte, error) {
+	return json.Marshal(state{MsgID: d.msgID})
+}
+
+func (d *dialog) RestoreState(data []byte) error {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to unmarshal state of settings: %w", err)
+	}
+	d.msgID = s.MsgID
+	return nil
+}
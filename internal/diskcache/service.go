@@ -0,0 +1,332 @@
+package diskcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/downloader"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+	"go.uber.org/zap"
+)
+
+const (
+	dataExt    = ".bin"
+	metaExt    = ".json"
+	partialExt = ".partial"
+)
+
+// Service is app.DownloadService which keeps finished downloads in the directory on disk. Repeated requests
+// of the same media are served from disk without downloading and transcoding. Total size of files is limited
+// by maxSize, least recently used files are evicted.
+//
+// Every entry consists of the data file and the metadata file. Files are written to temporary files
+// and renamed only when the whole stream is read, so partially downloaded media is never served.
+type Service struct {
+	app.DownloadService
+
+	dir     string
+	maxSize int64
+	now     func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	totalSize int64
+}
+
+type entry struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// New creates the cache in dir. Partial files left after the crash are removed, and the index of entries
+// is built from files in dir. Modification time of data file is used as the time of last usage.
+func New(service app.DownloadService, dir string, maxSize int64) (*Service, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	s := &Service{
+		DownloadService: service,
+		dir:             dir,
+		maxSize:         maxSize,
+		now:             time.Now,
+		entries:         make(map[string]*entry),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.evictLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Service) DownloadAudio(ctx context.Context, link string, opts app.DownloadOptions) (app.DownloadResult, error) {
+	return s.download(ctx, app.MediaAudio, link, opts, s.DownloadService.DownloadAudio)
+}
+
+func (s *Service) DownloadVideo(ctx context.Context, link string, opts app.DownloadOptions) (app.DownloadResult, error) {
+	return s.download(ctx, app.MediaVideo, link, opts, s.DownloadService.DownloadVideo)
+}
+
+type downloadFunc func(ctx context.Context, link string, opts app.DownloadOptions) (app.DownloadResult, error)
+
+func (s *Service) download(ctx context.Context, kind app.MediaKind, link string, opts app.DownloadOptions, download downloadFunc) (app.DownloadResult, error) {
+	log := logging.FromContextS(ctx)
+	videoID, err := downloader.ExtractVideoID(link)
+	if err != nil {
+		return download(ctx, link, opts)
+	}
+	keyOpts := opts
+	if kind == app.MediaAudio {
+		// The default profile may be changed by server config, transcodes of the old one mustn't be returned.
+		keyOpts.AudioProfile = s.AudioProfileName(opts.AudioProfile)
+	}
+	key := cacheKey(app.MediaCacheKey(videoID, app.DownloadRequest{Link: link, Kind: kind, Options: keyOpts}, 0))
+	if res, ok := s.open(ctx, key); ok {
+		log.Infof("Media is found in disk cache: %s", key)
+		return res, nil
+	}
+	res, err := download(ctx, link, opts)
+	if err != nil {
+		return res, err
+	}
+	tmp, err := os.CreateTemp(s.dir, key+".*"+partialExt)
+	if err != nil {
+		log.Warnf("Failed to create file in disk cache, media won't be cached: %v", err)
+		return res, nil
+	}
+	meta := res
+	meta.Stream = nil
+	res.Stream = &cachingStream{
+		src: res.Stream,
+		tmp: tmp,
+		commit: func(size int64) error {
+			return s.commit(key, meta, tmp.Name(), size)
+		},
+		log: log,
+	}
+	return res, nil
+}
+
+// open returns the cached media. ok is false if media isn't cached or can't be read.
+func (s *Service) open(ctx context.Context, key string) (res app.DownloadResult, ok bool) {
+	log := logging.FromContextS(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return app.DownloadResult{}, false
+	}
+	metaData, err := os.ReadFile(s.path(key, metaExt))
+	if err == nil {
+		err = json.Unmarshal(metaData, &res)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(s.path(key, dataExt))
+	}
+	if err != nil {
+		log.Warnf("Failed to open media from disk cache, it will be downloaded again: %v", err)
+		if err := s.removeLocked(key); err != nil {
+			log.Errorf("Failed to remove broken media from disk cache: %v", err)
+		}
+		return app.DownloadResult{}, false
+	}
+	e.lastUsed = s.now()
+	if err := os.Chtimes(s.path(key, dataExt), e.lastUsed, e.lastUsed); err != nil {
+		log.Warnf("Failed to update time of usage of cached media: %v", err)
+	}
+	res.Stream = f
+	return res, true
+}
+
+// commit moves the completely written temporary file to the cache.
+func (s *Service) commit(key string, meta app.DownloadResult, tmpPath string, size int64) error {
+	if size > s.maxSize {
+		_ = os.Remove(tmpPath)
+		return nil
+	}
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Metadata is written first, because entry without data file is ignored and removed on startup.
+	metaTmpPath := s.path(key, metaExt+partialExt)
+	if err := os.WriteFile(metaTmpPath, metaData, 0o644); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := os.Rename(metaTmpPath, s.path(key, metaExt)); err != nil {
+		_ = os.Remove(tmpPath)
+		_ = os.Remove(metaTmpPath)
+		return fmt.Errorf("failed to rename metadata file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path(key, dataExt)); err != nil {
+		_ = os.Remove(tmpPath)
+		_ = os.Remove(s.path(key, metaExt))
+		return fmt.Errorf("failed to rename data file: %w", err)
+	}
+	if old, ok := s.entries[key]; ok {
+		s.totalSize -= old.size
+	}
+	s.entries[key] = &entry{size: size, lastUsed: s.now()}
+	s.totalSize += size
+	return s.evictLocked()
+}
+
+// evictLocked removes least recently used entries until total size fits maxSize.
+func (s *Service) evictLocked() error {
+	for s.totalSize > s.maxSize && len(s.entries) > 0 {
+		var (
+			oldestKey string
+			oldest    *entry
+		)
+		for key, e := range s.entries {
+			if oldest == nil || e.lastUsed.Before(oldest.lastUsed) {
+				oldestKey, oldest = key, e
+			}
+		}
+		if err := s.removeLocked(oldestKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) removeLocked(key string) error {
+	if e, ok := s.entries[key]; ok {
+		s.totalSize -= e.size
+		delete(s.entries, key)
+	}
+	for _, ext := range []string{dataExt, metaExt} {
+		if err := os.Remove(s.path(key, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove cached file: %w", err)
+		}
+	}
+	return nil
+}
+
+// load removes partial files and files without pair and builds the index of entries.
+func (s *Service) load() error {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	files := make(map[string]struct{}, len(dirEntries))
+	for _, de := range dirEntries {
+		files[de.Name()] = struct{}{}
+	}
+	for _, de := range dirEntries {
+		name := de.Name()
+		path := filepath.Join(s.dir, name)
+		switch ext := filepath.Ext(name); {
+		case de.IsDir():
+			continue
+		case ext == partialExt:
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove partial file: %w", err)
+			}
+		case ext == dataExt:
+			key := strings.TrimSuffix(name, dataExt)
+			if _, ok := files[key+metaExt]; !ok {
+				if err := os.Remove(path); err != nil {
+					return fmt.Errorf("failed to remove data file without metadata: %w", err)
+				}
+				continue
+			}
+			info, err := de.Info()
+			if err != nil {
+				return fmt.Errorf("failed to stat cached file: %w", err)
+			}
+			s.entries[key] = &entry{size: info.Size(), lastUsed: info.ModTime()}
+			s.totalSize += info.Size()
+		case ext == metaExt:
+			if _, ok := files[strings.TrimSuffix(name, metaExt)+dataExt]; !ok {
+				if err := os.Remove(path); err != nil {
+					return fmt.Errorf("failed to remove metadata file without data: %w", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Service) path(key, ext string) string {
+	return filepath.Join(s.dir, key+ext)
+}
+
+// cacheKey turns the key of media into the name of file.
+func cacheKey(mediaKey string) string {
+	sum := sha256.Sum256([]byte(mediaKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// cachingStream copies the downloaded stream into the temporary file. The file is committed to the cache
+// when the stream is read to the end, otherwise it's removed.
+type cachingStream struct {
+	src    io.ReadCloser
+	tmp    *os.File
+	commit func(size int64) error
+	log    *zap.SugaredLogger
+
+	written int64
+	done    bool
+}
+
+func (s *cachingStream) Read(p []byte) (int, error) {
+	n, err := s.src.Read(p)
+	if !s.done && n > 0 {
+		if _, werr := s.tmp.Write(p[:n]); werr != nil {
+			s.log.Warnf("Failed to write media to disk cache: %v", werr)
+			s.discard()
+		}
+		s.written += int64(n)
+	}
+	if s.done || err == nil {
+		return n, err
+	}
+	if !errors.Is(err, io.EOF) {
+		s.discard()
+		return n, err
+	}
+	s.done = true
+	if cerr := s.tmp.Close(); cerr != nil {
+		s.log.Warnf("Failed to close file of disk cache: %v", cerr)
+		_ = os.Remove(s.tmp.Name())
+		return n, err
+	}
+	if cerr := s.commit(s.written); cerr != nil {
+		s.log.Warnf("Failed to put media to disk cache: %v", cerr)
+	}
+	return n, err
+}
+
+func (s *cachingStream) Close() error {
+	if !s.done {
+		s.discard()
+	}
+	return s.src.Close()
+}
+
+// discard removes the temporary file of incomplete media.
+func (s *cachingStream) discard() {
+	s.done = true
+	_ = s.tmp.Close()
+	_ = os.Remove(s.tmp.Name())
+}
@@ -0,0 +1,174 @@
+package diskcache
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+	"go.uber.org/zap"
+)
+
+// fakeService returns the content of video as audio stream and counts downloads.
+type fakeService struct {
+	app.DownloadService
+	contentByLink  map[string]string
+	defaultProfile string
+	downloads      int
+}
+
+func (f *fakeService) AudioProfileName(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	return f.defaultProfile
+}
+
+func (f *fakeService) DownloadAudio(ctx context.Context, link string, opts app.DownloadOptions) (app.DownloadResult, error) {
+	f.downloads++
+	content := f.contentByLink[link]
+	return app.DownloadResult{
+		ContentLen: int64(len(content)),
+		Name:       "title of " + link,
+		Ext:        "mp3",
+		Stream:     io.NopCloser(strings.NewReader(content)),
+	}, nil
+}
+
+const (
+	linkA = "https://youtu.be/aaaaaaaaaaa"
+	linkB = "https://youtu.be/bbbbbbbbbbb"
+)
+
+func newTestService(t *testing.T, dir string) (*Service, *fakeService) {
+	t.Helper()
+	fake := &fakeService{contentByLink: map[string]string{
+		linkA: strings.Repeat("a", 10),
+		linkB: strings.Repeat("b", 10),
+	}}
+	s, err := New(fake, dir, 15)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s, fake
+}
+
+func download(t *testing.T, s *Service, link string, readAll bool) app.DownloadResult {
+	t.Helper()
+	res, err := s.DownloadAudio(context.Background(), link, app.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("DownloadAudio() error = %v", err)
+	}
+	if readAll {
+		if _, err := io.ReadAll(res.Stream); err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+	} else {
+		if _, err := res.Stream.Read(make([]byte, 3)); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if err := res.Stream.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return res
+}
+
+func TestService(t *testing.T) {
+	logging.SetLogger(zap.NewExample())
+	dir := t.TempDir()
+	s, fake := newTestService(t, dir)
+
+	// Partially read media isn't cached.
+	download(t, s, linkA, false)
+	download(t, s, linkA, true)
+	if fake.downloads != 2 {
+		t.Errorf("downloads = %d, want 2", fake.downloads)
+	}
+
+	res, err := s.DownloadAudio(context.Background(), linkA, app.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("DownloadAudio() error = %v", err)
+	}
+	data, _ := io.ReadAll(res.Stream)
+	_ = res.Stream.Close()
+	if fake.downloads != 2 || string(data) != strings.Repeat("a", 10) || res.Name != "title of "+linkA {
+		t.Errorf("cached media = %q %+v after %d downloads, want media served from cache", data, res, fake.downloads)
+	}
+
+	// Different options are different media.
+	res, err = s.DownloadAudio(context.Background(), linkA, app.DownloadOptions{AudioProfile: "flac"})
+	if err != nil {
+		t.Fatalf("DownloadAudio() error = %v", err)
+	}
+	_ = res.Stream.Close()
+	if fake.downloads != 3 {
+		t.Errorf("downloads = %d, want 3", fake.downloads)
+	}
+
+	// Both media don't fit max size, so the least recently used one is evicted.
+	download(t, s, linkB, true)
+	download(t, s, linkA, true)
+	if fake.downloads != 5 {
+		t.Errorf("downloads = %d, want 5", fake.downloads)
+	}
+	download(t, s, linkA, true)
+	if fake.downloads != 5 {
+		t.Errorf("downloads = %d, want 5 when media is served from cache", fake.downloads)
+	}
+}
+
+func TestService_defaultProfile(t *testing.T) {
+	logging.SetLogger(zap.NewExample())
+	s, fake := newTestService(t, t.TempDir())
+	fake.defaultProfile = "mp3-v2"
+	download(t, s, linkA, true)
+	download(t, s, linkA, true)
+	if fake.downloads != 1 {
+		t.Errorf("downloads = %d, want 1 when media is served from cache", fake.downloads)
+	}
+
+	// Transcode of the old default profile isn't returned after the default is changed.
+	fake.defaultProfile = "flac"
+	download(t, s, linkA, true)
+	if fake.downloads != 2 {
+		t.Errorf("downloads = %d, want 2 after default profile is changed", fake.downloads)
+	}
+}
+
+func TestNew(t *testing.T) {
+	logging.SetLogger(zap.NewExample())
+	dir := t.TempDir()
+	s, _ := newTestService(t, dir)
+	download(t, s, linkA, true)
+
+	for _, name := range []string{"abc.123.partial", "orphan" + dataExt, "orphan2" + metaExt} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Data file of media is older than the time of restart.
+	old := time.Now().Add(-time.Hour)
+	for key := range s.entries {
+		_ = os.Chtimes(s.path(key, dataExt), old, old)
+	}
+
+	s, fake := newTestService(t, dir)
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("files after restart = %v, want only data and metadata of cached media", names)
+	}
+	download(t, s, linkA, true)
+	if fake.downloads != 0 {
+		t.Errorf("downloads = %d, want media restored from disk", fake.downloads)
+	}
+}
@@ -0,0 +1,67 @@
+package downloader
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+const timestampPattern = `((?:\d{1,2}:)?\d{1,2}:\d{2})`
+
+var (
+	chapterLeadingTimeRegexp  = regexp.MustCompile(`^[\[(]?` + timestampPattern + `[\])]?\s*[-–—:|.]?\s*(.+)$`)
+	chapterTrailingTimeRegexp = regexp.MustCompile(`^(.+?)\s*[-–—:|]?\s*[\[(]?` + timestampPattern + `[\])]?$`)
+)
+
+// parseChapters finds chapters in the video description. YouTube makes chapters from the lines with timestamps
+// like "0:00 Intro" or "Intro - 0:00", so the same rules are used: the first chapter must start at 0:00,
+// there must be at least two chapters, and timestamps must ascend. Otherwise nil is returned.
+// End of the last chapter is the video duration.
+func parseChapters(description string, duration time.Duration) []app.Chapter {
+	var chapters []app.Chapter
+	for _, line := range strings.Split(description, "\n") {
+		line = strings.TrimSpace(line)
+		var (
+			timestamp string
+			title     string
+		)
+		if m := chapterLeadingTimeRegexp.FindStringSubmatch(line); m != nil {
+			timestamp, title = m[1], m[2]
+		} else if m := chapterTrailingTimeRegexp.FindStringSubmatch(line); m != nil {
+			title, timestamp = m[1], m[2]
+		} else {
+			continue
+		}
+		start, err := ParseTimestamp(timestamp)
+		if err != nil {
+			continue
+		}
+		if len(chapters) == 0 && start != 0 {
+			return nil
+		}
+		if len(chapters) > 0 {
+			prev := &chapters[len(chapters)-1]
+			if start <= prev.Start {
+				return nil
+			}
+			prev.End = start
+		}
+		chapters = append(chapters, app.Chapter{
+			Title:     strings.TrimSpace(title),
+			TimeRange: app.TimeRange{Start: start},
+		})
+	}
+	if len(chapters) < 2 {
+		return nil
+	}
+	if duration > 0 {
+		last := &chapters[len(chapters)-1]
+		if last.Start >= duration {
+			return nil
+		}
+		last.End = duration
+	}
+	return chapters
+}
@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+func Test_parseChapters(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		duration    time.Duration
+		want        []app.Chapter
+	}{
+		{
+			name:        "should_parse_leading_timestamps",
+			description: "Tracklist:\n0:00 Intro\n[1:30] - First song\n(12:05) Second song\n\nThanks for watching!",
+			duration:    15 * time.Minute,
+			want: []app.Chapter{
+				{Title: "Intro", TimeRange: app.TimeRange{Start: 0, End: 90 * time.Second}},
+				{Title: "First song", TimeRange: app.TimeRange{Start: 90 * time.Second, End: 12*time.Minute + 5*time.Second}},
+				{Title: "Second song", TimeRange: app.TimeRange{Start: 12*time.Minute + 5*time.Second, End: 15 * time.Minute}},
+			},
+		},
+		{
+			name:        "should_parse_trailing_timestamps",
+			description: "Intro - 00:00\nOutro 1:02:03",
+			duration:    2 * time.Hour,
+			want: []app.Chapter{
+				{Title: "Intro", TimeRange: app.TimeRange{Start: 0, End: time.Hour + 2*time.Minute + 3*time.Second}},
+				{Title: "Outro", TimeRange: app.TimeRange{Start: time.Hour + 2*time.Minute + 3*time.Second, End: 2 * time.Hour}},
+			},
+		},
+		{
+			name:        "should_return_nil_when_first_chapter_not_at_zero",
+			description: "1:00 First\n2:00 Second",
+			duration:    time.Hour,
+		},
+		{
+			name:        "should_return_nil_when_timestamps_not_ascend",
+			description: "0:00 First\n5:00 Second\n2:00 Third",
+			duration:    time.Hour,
+		},
+		{
+			name:        "should_return_nil_for_single_timestamp",
+			description: "Starts at 0:00",
+			duration:    time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseChapters(tt.description, tt.duration)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseChapters() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
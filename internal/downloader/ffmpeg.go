@@ -0,0 +1,23 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// FFmpegVersion runs "ffmpeg -version" and returns the first line of its output.
+// It fails if ffmpeg isn't found on PATH or can't be executed.
+func FFmpegVersion(ctx context.Context) (string, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", fmt.Errorf("failed to find ffmpeg: %w", err)
+	}
+	output, err := exec.CommandContext(ctx, path, "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", path, err)
+	}
+	line, _, _ := bytes.Cut(output, []byte("\n"))
+	return string(bytes.TrimSpace(line)), nil
+}
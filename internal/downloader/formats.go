@@ -0,0 +1,131 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+const (
+	videoMP4PatternMime = "video/mp4"
+	audioMP4PatternMime = "audio/mp4"
+
+	// defaultVideoMaxHeight limits the resolution of downloaded videos. Bigger videos hardly fit into Telegram limits.
+	defaultVideoMaxHeight = 720
+)
+
+// selectVideoOnlyFormat returns the video-only MP4 format with the biggest height not exceeding maxHeight.
+// Formats with the same height are compared by bitrate.
+func selectVideoOnlyFormat(formats youtube.FormatList, maxHeight int) (*youtube.Format, error) {
+	var best *youtube.Format
+	for i := range formats {
+		f := &formats[i]
+		if f.AudioChannels > 0 || !strings.Contains(f.MimeType, videoMP4PatternMime) {
+			continue
+		}
+		if maxHeight > 0 && f.Height > maxHeight {
+			continue
+		}
+		if best == nil || f.Height > best.Height || (f.Height == best.Height && f.Bitrate > best.Bitrate) {
+			best = f
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no video-only format found for type pattern %q and max height %d", videoMP4PatternMime, maxHeight)
+	}
+	return best, nil
+}
+
+// selectAudioOnlyFormat returns the audio-only MP4 format with the biggest bitrate.
+func selectAudioOnlyFormat(formats youtube.FormatList) (*youtube.Format, error) {
+	var best *youtube.Format
+	for i := range formats {
+		f := &formats[i]
+		if f.AudioChannels == 0 || !strings.Contains(f.MimeType, audioMP4PatternMime) {
+			continue
+		}
+		if best == nil || f.Bitrate > best.Bitrate {
+			best = f
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no audio-only format found for type pattern %q", audioMP4PatternMime)
+	}
+	return best, nil
+}
+
+// listFormats converts formats of the video to the list which can be shown to user.
+// Audio formats go first sorted by bitrate, then MP4 video formats sorted by height. Only the best bitrate
+// is kept for each video quality.
+func listFormats(formats youtube.FormatList, duration time.Duration) []app.FormatInfo {
+	var (
+		audios []app.FormatInfo
+		videos []app.FormatInfo
+
+		videoIdxByQuality = make(map[string]int)
+	)
+	for i := range formats {
+		f := &formats[i]
+		info := app.FormatInfo{
+			Itag:          f.ItagNo,
+			MimeType:      f.MimeType,
+			Codec:         parseCodec(f.MimeType),
+			QualityLabel:  f.QualityLabel,
+			Bitrate:       f.Bitrate,
+			EstimatedSize: estimateSize(f, duration),
+		}
+		switch {
+		case strings.HasPrefix(f.MimeType, "audio/"):
+			info.Kind = app.MediaAudio
+			audios = append(audios, info)
+		case strings.Contains(f.MimeType, videoMP4PatternMime):
+			info.Kind = app.MediaVideo
+			info.WithAudio = f.AudioChannels > 0
+			key := fmt.Sprintf("%s/%t", f.QualityLabel, info.WithAudio)
+			if idx, ok := videoIdxByQuality[key]; ok {
+				if videos[idx].Bitrate < info.Bitrate {
+					videos[idx] = info
+				}
+				continue
+			}
+			videoIdxByQuality[key] = len(videos)
+			videos = append(videos, info)
+		}
+	}
+	sort.SliceStable(audios, func(i, j int) bool {
+		return audios[i].Bitrate > audios[j].Bitrate
+	})
+	heights := make(map[int]int, len(formats))
+	for _, f := range formats {
+		heights[f.ItagNo] = f.Height
+	}
+	sort.SliceStable(videos, func(i, j int) bool {
+		return heights[videos[i].Itag] > heights[videos[j].Itag]
+	})
+	return append(audios, videos...)
+}
+
+// parseCodec extracts codecs from mime type like 'video/mp4; codecs="avc1.640028"'.
+func parseCodec(mimeType string) string {
+	const codecsPrefix = "codecs="
+	idx := strings.Index(mimeType, codecsPrefix)
+	if idx < 0 {
+		return ""
+	}
+	return strings.Trim(mimeType[idx+len(codecsPrefix):], `"`)
+}
+
+func estimateSize(f *youtube.Format, duration time.Duration) int64 {
+	if f.ContentLength > 0 {
+		return f.ContentLength
+	}
+	bitrate := f.AverageBitrate
+	if bitrate == 0 {
+		bitrate = f.Bitrate
+	}
+	return int64(float64(bitrate) / 8 * duration.Seconds())
+}
@@ -0,0 +1,129 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+func Test_selectVideoOnlyFormat(t *testing.T) {
+	formats := youtube.FormatList{
+		{ItagNo: 18, MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, Height: 360, AudioChannels: 2, Bitrate: 500},
+		{ItagNo: 137, MimeType: `video/mp4; codecs="avc1.640028"`, Height: 1080, Bitrate: 4000},
+		{ItagNo: 136, MimeType: `video/mp4; codecs="avc1.4d401f"`, Height: 720, Bitrate: 1500},
+		{ItagNo: 398, MimeType: `video/mp4; codecs="av01.0.05M.08"`, Height: 720, Bitrate: 1800},
+		{ItagNo: 247, MimeType: `video/webm; codecs="vp9"`, Height: 720, Bitrate: 2000},
+		{ItagNo: 135, MimeType: `video/mp4; codecs="avc1.4d401e"`, Height: 480, Bitrate: 800},
+	}
+	tests := []struct {
+		name      string
+		maxHeight int
+		wantItag  int
+		wantErr   bool
+	}{
+		{
+			name:      "should_return_best_mp4_within_height_limit",
+			maxHeight: 720,
+			wantItag:  398,
+		},
+		{
+			name:      "should_return_best_mp4_when_no_limit",
+			maxHeight: 0,
+			wantItag:  137,
+		},
+		{
+			name:      "should_skip_formats_with_audio",
+			maxHeight: 480,
+			wantItag:  135,
+		},
+		{
+			name:      "should_return_err_when_nothing_fits",
+			maxHeight: 144,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectVideoOnlyFormat(formats, tt.maxHeight)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectVideoOnlyFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.ItagNo != tt.wantItag {
+				t.Errorf("selectVideoOnlyFormat() itag = %v, want %v", got.ItagNo, tt.wantItag)
+			}
+		})
+	}
+}
+
+func Test_selectAudioOnlyFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		formats  youtube.FormatList
+		wantItag int
+		wantErr  bool
+	}{
+		{
+			name: "should_return_mp4_audio_with_best_bitrate",
+			formats: youtube.FormatList{
+				{ItagNo: 251, MimeType: `audio/webm; codecs="opus"`, AudioChannels: 2, Bitrate: 160000},
+				{ItagNo: 139, MimeType: `audio/mp4; codecs="mp4a.40.5"`, AudioChannels: 2, Bitrate: 48000},
+				{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AudioChannels: 2, Bitrate: 128000},
+			},
+			wantItag: 140,
+		},
+		{
+			name: "should_return_err_without_mp4_audio",
+			formats: youtube.FormatList{
+				{ItagNo: 251, MimeType: `audio/webm; codecs="opus"`, AudioChannels: 2, Bitrate: 160000},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectAudioOnlyFormat(tt.formats)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectAudioOnlyFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.ItagNo != tt.wantItag {
+				t.Errorf("selectAudioOnlyFormat() itag = %v, want %v", got.ItagNo, tt.wantItag)
+			}
+		})
+	}
+}
+
+func Test_listFormats(t *testing.T) {
+	formats := youtube.FormatList{
+		{ItagNo: 137, MimeType: `video/mp4; codecs="avc1.640028"`, QualityLabel: "1080p", Height: 1080, Bitrate: 4000, ContentLength: 4000},
+		{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AudioChannels: 2, Bitrate: 128000},
+		{ItagNo: 136, MimeType: `video/mp4; codecs="avc1.4d401f"`, QualityLabel: "720p", Height: 720, Bitrate: 1500},
+		{ItagNo: 398, MimeType: `video/mp4; codecs="av01.0.05M.08"`, QualityLabel: "720p", Height: 720, Bitrate: 1800},
+		{ItagNo: 247, MimeType: `video/webm; codecs="vp9"`, QualityLabel: "720p", Height: 720, Bitrate: 2000},
+		{ItagNo: 251, MimeType: `audio/webm; codecs="opus"`, AudioChannels: 2, Bitrate: 160000},
+		{ItagNo: 18, MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, QualityLabel: "360p", Height: 360, AudioChannels: 2, Bitrate: 500},
+	}
+	got := listFormats(formats, 10*time.Second)
+
+	wantItags := []int{251, 140, 137, 398, 18}
+	if len(got) != len(wantItags) {
+		t.Fatalf("listFormats() returned %d formats, want %d", len(got), len(wantItags))
+	}
+	for i, itag := range wantItags {
+		if got[i].Itag != itag {
+			t.Errorf("listFormats()[%d].Itag = %v, want %v", i, got[i].Itag, itag)
+		}
+	}
+	if got[1].Codec != "mp4a.40.2" {
+		t.Errorf("listFormats()[1].Codec = %q, want %q", got[1].Codec, "mp4a.40.2")
+	}
+	if got[1].EstimatedSize != 160000 {
+		t.Errorf("listFormats()[1].EstimatedSize = %v, want %v", got[1].EstimatedSize, 160000)
+	}
+	if got[2].EstimatedSize != 4000 {
+		t.Errorf("listFormats()[2].EstimatedSize = %v, want %v", got[2].EstimatedSize, 4000)
+	}
+	if !got[4].WithAudio || got[3].WithAudio {
+		t.Errorf("listFormats() WithAudio flags are wrong: %+v", got)
+	}
+}
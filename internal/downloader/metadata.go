@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+const (
+	// telegramThumbMaxSide is the limit of thumbnail width and height accepted by Telegram.
+	telegramThumbMaxSide = 320
+	thumbnailMaxBytes    = 5 << 20
+)
+
+// buildMeta fills metadata of the audio from the video. The channel name is used as artist.
+// Thumbnail for Telegram is fetched too, failure of fetching is logged only.
+func (s *Service) buildMeta(ctx context.Context, video *youtube.Video) app.MediaMeta {
+	log := logging.FromContextS(ctx)
+	meta := app.MediaMeta{
+		Title:    video.Title,
+		Artist:   video.Author,
+		Album:    video.Title,
+		Duration: video.Duration,
+	}
+	if !video.PublishDate.IsZero() {
+		meta.Year = video.PublishDate.Year()
+	}
+	if thumb, ok := selectThumbnail(video.Thumbnails, telegramThumbMaxSide, true); ok {
+		data, err := s.fetchThumbnail(ctx, thumb.URL)
+		if err != nil {
+			log.Warnf("Failed to fetch thumbnail for Telegram: %v", err)
+		} else {
+			meta.Thumbnail = data
+		}
+	}
+	return meta
+}
+
+// fetchCover returns the biggest thumbnail of the video to embed it as cover art. Nil is returned on failure.
+func (s *Service) fetchCover(ctx context.Context, video *youtube.Video) []byte {
+	thumb, ok := selectThumbnail(video.Thumbnails, 0, false)
+	if !ok {
+		return nil
+	}
+	data, err := s.fetchThumbnail(ctx, thumb.URL)
+	if err != nil {
+		logging.FromContextS(ctx).Warnf("Failed to fetch cover art: %v", err)
+		return nil
+	}
+	return data
+}
+
+func (s *Service) fetchThumbnail(ctx context.Context, thumbURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, thumbURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d of thumbnail %q", resp.StatusCode, thumbURL)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, thumbnailMaxBytes))
+}
+
+// selectThumbnail returns the biggest thumbnail with both sides not exceeding maxSide (zero means no limit).
+// If jpegOnly is set, only thumbnails with .jpg extension are considered.
+func selectThumbnail(thumbs youtube.Thumbnails, maxSide uint, jpegOnly bool) (youtube.Thumbnail, bool) {
+	var (
+		best  youtube.Thumbnail
+		found bool
+	)
+	for _, t := range thumbs {
+		if maxSide > 0 && (t.Width > maxSide || t.Height > maxSide) {
+			continue
+		}
+		if jpegOnly && !isJPEGURL(t.URL) {
+			continue
+		}
+		if !found || t.Width > best.Width {
+			best, found = t, true
+		}
+	}
+	return best, found
+}
+
+func isJPEGURL(thumbURL string) bool {
+	path := thumbURL
+	if idx := strings.IndexByte(path, '?'); idx >= 0 {
+		path = path[:idx]
+	}
+	return strings.HasSuffix(path, ".jpg") || strings.HasSuffix(path, ".jpeg")
+}
+
+// metadataArgs returns ffmpeg args to write tags of the output file.
+func metadataArgs(meta app.MediaMeta) []string {
+	args := []string{
+		"-metadata", "title=" + meta.Title,
+		"-metadata", "artist=" + meta.Artist,
+		"-metadata", "album=" + meta.Album,
+	}
+	if meta.Year > 0 {
+		args = append(args, "-metadata", "date="+strconv.Itoa(meta.Year))
+	}
+	return args
+}
@@ -0,0 +1,52 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+func Test_selectThumbnail(t *testing.T) {
+	thumbs := youtube.Thumbnails{
+		{URL: "https://i.ytimg.com/vi/id/default.jpg", Width: 120, Height: 90},
+		{URL: "https://i.ytimg.com/vi/id/mqdefault.jpg?sqp=abc", Width: 320, Height: 180},
+		{URL: "https://i.ytimg.com/vi_webp/id/mqdefault.webp", Width: 320, Height: 320},
+		{URL: "https://i.ytimg.com/vi/id/hqdefault.jpg", Width: 480, Height: 360},
+		{URL: "https://i.ytimg.com/vi/id/maxresdefault.jpg", Width: 1280, Height: 720},
+	}
+	tests := []struct {
+		name      string
+		maxSide   uint
+		jpegOnly  bool
+		wantURL   string
+		wantFound bool
+	}{
+		{
+			name:      "should_return_biggest_without_limit",
+			wantURL:   "https://i.ytimg.com/vi/id/maxresdefault.jpg",
+			wantFound: true,
+		},
+		{
+			name:      "should_return_biggest_jpeg_within_telegram_limit",
+			maxSide:   320,
+			jpegOnly:  true,
+			wantURL:   "https://i.ytimg.com/vi/id/mqdefault.jpg?sqp=abc",
+			wantFound: true,
+		},
+		{
+			name:    "should_not_find_too_small_limit",
+			maxSide: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := selectThumbnail(thumbs, tt.maxSide, tt.jpegOnly)
+			if found != tt.wantFound {
+				t.Fatalf("selectThumbnail() found = %v, want %v", found, tt.wantFound)
+			}
+			if got.URL != tt.wantURL {
+				t.Errorf("selectThumbnail() url = %v, want %v", got.URL, tt.wantURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+	"go.uber.org/zap"
+)
+
+const videoLinkPrefix = "https://www.youtube.com/watch?v="
+
+// GetPlaylistInfo returns the list of videos of the playlist.
+func (s *Service) GetPlaylistInfo(ctx context.Context, link string) (app.PlaylistInfo, error) {
+	ctx = logging.NewContextS(ctx, zap.String("playlist_link", link))
+	log := logging.FromContextS(ctx)
+	playlist, err := new(youtube.Client).GetPlaylistContext(ctx, link)
+	if err != nil {
+		return app.PlaylistInfo{}, fmt.Errorf("failed to get playlist by link: %w", err)
+	}
+	info := app.PlaylistInfo{
+		Title:   playlist.Title,
+		Author:  playlist.Author,
+		Entries: make([]app.PlaylistEntry, 0, len(playlist.Videos)),
+	}
+	for _, v := range playlist.Videos {
+		info.Entries = append(info.Entries, app.PlaylistEntry{
+			Link:     videoLinkPrefix + v.ID,
+			Title:    v.Title,
+			Author:   v.Author,
+			Duration: v.Duration,
+		})
+	}
+	log.Infof("Got playlist metadata with %d videos", len(info.Entries))
+	return info, nil
+}
+
+// ParseTrackSelection parses numbers of tracks like "1-10", "1,3,5-7" or "2 4 6". Numbers start from 1.
+// Returned indexes start from 0, they are sorted and unique.
+func ParseTrackSelection(text string, tracksCount int) ([]int, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("string %q doesn't contain track numbers", text)
+	}
+	selected := make(map[int]struct{})
+	for _, field := range fields {
+		from, to, isRange := strings.Cut(field, "-")
+		if !isRange {
+			to = from
+		}
+		start, err := parseTrackNum(from, tracksCount)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTrackNum(to, tracksCount)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("end %d of range must not be before start %d", end, start)
+		}
+		for i := start; i <= end; i++ {
+			selected[i-1] = struct{}{}
+		}
+	}
+	indexes := make([]int, 0, len(selected))
+	for i := range selected {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+func parseTrackNum(s string, tracksCount int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid track number %q", s)
+	}
+	if n < 1 || n > tracksCount {
+		return 0, fmt.Errorf("track number %d is out of range 1-%d", n, tracksCount)
+	}
+	return n, nil
+}
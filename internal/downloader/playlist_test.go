@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTrackSelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "should_parse_range",
+			text: "1-3",
+			want: []int{0, 1, 2},
+		},
+		{
+			name: "should_parse_list_with_ranges",
+			text: "5-6, 1,3",
+			want: []int{0, 2, 4, 5},
+		},
+		{
+			name: "should_remove_duplicates",
+			text: "2 2 1-2",
+			want: []int{0, 1},
+		},
+		{
+			name:    "should_return_err_on_out_of_range",
+			text:    "9-11",
+			wantErr: true,
+		},
+		{
+			name:    "should_return_err_on_reversed_range",
+			text:    "4-2",
+			wantErr: true,
+		},
+		{
+			name:    "should_return_err_on_text",
+			text:    "все",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTrackSelection(tt.text, 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTrackSelection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTrackSelection() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// processStream reads stdout of the started process. The process is waited when stdout is read to the end,
+// so the failure of process is returned instead of io.EOF and truncated output isn't taken for complete one.
+type processStream struct {
+	stdout io.ReadCloser
+	cmd    *exec.Cmd
+	// onExit is called after the process is exited, e.g. to release its inputs.
+	onExit func(err error)
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+func newProcessStream(cmd *exec.Cmd, stdout io.ReadCloser, onExit func(err error)) *processStream {
+	return &processStream{
+		stdout: stdout,
+		cmd:    cmd,
+		onExit: onExit,
+	}
+}
+
+func (s *processStream) Read(p []byte) (int, error) {
+	n, err := s.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		if waitErr := s.wait(); waitErr != nil {
+			return n, fmt.Errorf("process %q is failed: %w", s.cmd.Path, waitErr)
+		}
+	}
+	return n, err
+}
+
+// Close kills the process if its output isn't read to the end and waits for it.
+func (s *processStream) Close() error {
+	s.waitOnce.Do(func() {
+		_ = s.cmd.Process.Kill()
+		s.waitErr = s.cmd.Wait()
+		if s.onExit != nil {
+			s.onExit(s.waitErr)
+		}
+	})
+	return nil
+}
+
+func (s *processStream) wait() error {
+	s.waitOnce.Do(func() {
+		s.waitErr = s.cmd.Wait()
+		if s.onExit != nil {
+			s.onExit(s.waitErr)
+		}
+	})
+	return s.waitErr
+}
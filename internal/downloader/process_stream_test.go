@@ -0,0 +1,55 @@
+package downloader
+
+import (
+	"io"
+	"os/exec"
+	"testing"
+)
+
+func TestProcessStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		wantData string
+		wantErr  bool
+	}{
+		{
+			name:     "should_return_output_of_successful_process",
+			script:   "printf abc",
+			wantData: "abc",
+		},
+		{
+			name:     "should_return_error_of_failed_process_instead_of_eof",
+			script:   "printf ab; exit 3",
+			wantData: "ab",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command("sh", "-c", tt.script)
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cmd.Start(); err != nil {
+				t.Skipf("sh can't be started: %v", err)
+			}
+			var exited bool
+			s := newProcessStream(cmd, stdout, func(error) { exited = true })
+			data, err := io.ReadAll(s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("ReadAll() = %q, want %q", data, tt.wantData)
+			}
+			if !exited {
+				t.Errorf("onExit isn't called after reading to the end")
+			}
+			if err := s.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AudioProfile describes how downloaded audio is transcoded by ffmpeg.
+type AudioProfile struct {
+	Name  string
+	Title string
+	Ext   string
+
+	// coverArt is set when the container can hold embedded picture.
+	coverArt bool
+	// sourceMime restricts the source formats, e.g. passthrough profiles can't copy codec from other containers.
+	sourceMime string
+	ffmpegArgs []string
+}
+
+// SupportsSource reports whether the audio of specified mime type can be transcoded with this profile.
+func (p AudioProfile) SupportsSource(mimeType string) bool {
+	return p.sourceMime == "" || strings.Contains(mimeType, p.sourceMime)
+}
+
+// SupportsDefaultSource reports whether the profile can transcode audio downloaded when format isn't chosen by user.
+func (p AudioProfile) SupportsDefaultSource() bool {
+	return p.SupportsSource(audioMP4PatternMime)
+}
+
+const DefaultAudioProfileName = "mp3-v2"
+
+var audioProfiles = []AudioProfile{
+	{
+		Name:       "mp3-320",
+		Title:      "MP3 320 kbps",
+		Ext:        "mp3",
+		coverArt:   true,
+		ffmpegArgs: []string{"-c:a", "libmp3lame", "-b:a", "320k", "-f", "mp3"},
+	},
+	{
+		Name:       "mp3-192",
+		Title:      "MP3 192 kbps",
+		Ext:        "mp3",
+		coverArt:   true,
+		ffmpegArgs: []string{"-c:a", "libmp3lame", "-b:a", "192k", "-f", "mp3"},
+	},
+	{
+		Name:       "mp3-128",
+		Title:      "MP3 128 kbps",
+		Ext:        "mp3",
+		coverArt:   true,
+		ffmpegArgs: []string{"-c:a", "libmp3lame", "-b:a", "128k", "-f", "mp3"},
+	},
+	{
+		Name:       "mp3-v0",
+		Title:      "MP3 VBR V0 (~245 kbps)",
+		Ext:        "mp3",
+		coverArt:   true,
+		ffmpegArgs: []string{"-c:a", "libmp3lame", "-q:a", "0", "-f", "mp3"},
+	},
+	{
+		Name:       "mp3-v2",
+		Title:      "MP3 VBR V2 (~190 kbps)",
+		Ext:        "mp3",
+		coverArt:   true,
+		ffmpegArgs: []string{"-c:a", "libmp3lame", "-q:a", "2", "-f", "mp3"},
+	},
+	{
+		Name:       "opus-128",
+		Title:      "Opus/OGG 128 kbps",
+		Ext:        "ogg",
+		ffmpegArgs: []string{"-c:a", "libopus", "-b:a", "128k", "-f", "ogg"},
+	},
+	{
+		Name:       "opus-64",
+		Title:      "Opus/OGG 64 kbps",
+		Ext:        "ogg",
+		ffmpegArgs: []string{"-c:a", "libopus", "-b:a", "64k", "-f", "ogg"},
+	},
+	{
+		Name:       "m4a",
+		Title:      "M4A без перекодирования",
+		Ext:        "m4a",
+		sourceMime: audioMP4PatternMime,
+		ffmpegArgs: []string{"-vn", "-c:a", "copy", "-movflags", "frag_keyframe+empty_moov", "-f", "ipod"},
+	},
+	{
+		Name:       "flac",
+		Title:      "FLAC",
+		Ext:        "flac",
+		coverArt:   true,
+		ffmpegArgs: []string{"-c:a", "flac", "-f", "flac"},
+	},
+}
+
+// AudioProfiles returns all supported audio profiles.
+func AudioProfiles() []AudioProfile {
+	return audioProfiles
+}
+
+// AudioProfileByName returns audio profile by its name like "mp3-320" or "flac".
+func AudioProfileByName(name string) (AudioProfile, error) {
+	for _, p := range audioProfiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	names := make([]string, 0, len(audioProfiles))
+	for _, p := range audioProfiles {
+		names = append(names, p.Name)
+	}
+	return AudioProfile{}, fmt.Errorf("unknown audio profile %q, supported profiles: %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import "testing"
+
+func TestAudioProfileByName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantExt string
+		wantErr bool
+	}{
+		{
+			name:    "mp3-320",
+			wantExt: "mp3",
+		},
+		{
+			name:    "opus-128",
+			wantExt: "ogg",
+		},
+		{
+			name:    "flac",
+			wantExt: "flac",
+		},
+		{
+			name:    "wav",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AudioProfileByName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AudioProfileByName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Ext != tt.wantExt {
+				t.Errorf("AudioProfileByName() ext = %v, want %v", got.Ext, tt.wantExt)
+			}
+		})
+	}
+}
+
+func TestAudioProfile_SupportsSource(t *testing.T) {
+	m4a, err := AudioProfileByName("m4a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m4a.SupportsSource(`audio/mp4; codecs="mp4a.40.2"`) {
+		t.Error("m4a passthrough should support mp4 audio")
+	}
+	if m4a.SupportsSource(`audio/webm; codecs="opus"`) {
+		t.Error("m4a passthrough shouldn't support webm audio")
+	}
+	mp3, err := AudioProfileByName(DefaultAudioProfileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mp3.SupportsSource(`audio/webm; codecs="opus"`) {
+		t.Error("default profile should support any source")
+	}
+}
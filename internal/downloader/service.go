@@ -5,109 +5,387 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/kkdai/youtube/v2"
 	"github.com/vm-affekt/tgytbot/internal/app"
 	"github.com/vm-affekt/tgytbot/internal/logging"
+	"github.com/vm-affekt/tgytbot/internal/metrics"
 	"go.uber.org/zap"
 )
 
 type Service struct {
-	debugMode bool
+	debugMode           bool
+	defaultAudioProfile AudioProfile
+	streamOpts          StreamOptions
 }
 
-func New(debugMode bool) *Service {
+func New(debugMode bool, defaultAudioProfile AudioProfile, streamOpts StreamOptions) *Service {
 	return &Service{
-		debugMode: debugMode,
+		debugMode:           debugMode,
+		defaultAudioProfile: defaultAudioProfile,
+		streamOpts:          streamOpts,
 	}
 }
 
-func (s *Service) DownloadAudio(ctx context.Context, link string) (result app.DownloadResult, err error) {
-	const audioMP4PatternMime = "audio/mp4"
+func (s *Service) AudioProfileName(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	return s.defaultAudioProfile.Name
+}
+
+// GetVideoInfo returns metadata of the video with formats available for downloading.
+func (s *Service) GetVideoInfo(ctx context.Context, link string) (app.VideoInfo, error) {
 	ctx = logging.NewContextS(ctx, zap.String("video_link", link))
+	video, err := s.getVideo(ctx, new(youtube.Client), link)
+	if err != nil {
+		return app.VideoInfo{}, err
+	}
+	return app.VideoInfo{
+		Title:    video.Title,
+		Duration: video.Duration,
+		Formats:  listFormats(video.Formats, video.Duration),
+		Chapters: parseChapters(video.Description, video.Duration),
+	}, nil
+}
 
-	mp4DownloadRes, err := s.downloadStream(ctx, link, audioMP4PatternMime)
+func (s *Service) DownloadAudio(ctx context.Context, link string, opts app.DownloadOptions) (result app.DownloadResult, err error) {
+	ctx = logging.NewContextS(ctx, zap.String("video_link", link))
+
+	profile := s.defaultAudioProfile
+	if opts.AudioProfile != "" {
+		profile, err = AudioProfileByName(opts.AudioProfile)
+		if err != nil {
+			return app.DownloadResult{}, err
+		}
+	}
+
+	sourceDownloadRes, video, err := s.downloadStream(ctx, link, opts, profile)
 	if err != nil {
 		return app.DownloadResult{}, fmt.Errorf("failed to start downloading stream: %w", err)
 	}
 
-	mp3Stream, err := s.convertMP4ToMP3(ctx, mp4DownloadRes.Stream)
+	meta := s.buildMeta(ctx, video)
+	if opts.Album != "" {
+		meta.Album = opts.Album
+	}
+	if !opts.Clip.IsZero() {
+		meta.Duration = opts.Clip.Duration(video.Duration)
+	}
+	var cover []byte
+	if profile.coverArt {
+		cover = s.fetchCover(ctx, video)
+	}
+	audioStream, err := s.transcodeAudio(ctx, sourceDownloadRes.Stream, profile, meta, cover, opts.Clip)
 	if err != nil {
-		return app.DownloadResult{}, fmt.Errorf("failed to convert from mp4 to mp3: %w", err)
+		_ = sourceDownloadRes.Stream.Close()
+		return app.DownloadResult{}, fmt.Errorf("failed to transcode audio with profile %q: %w", profile.Name, err)
 	}
-	return app.DownloadResult{
-		ContentLen: mp4DownloadRes.ContentLen,
-		Name:       mp4DownloadRes.Name,
-		Stream:     mp3Stream,
-	}, nil
+	result = app.DownloadResult{
+		ContentLen:     sourceDownloadRes.ContentLen,
+		Name:           sourceDownloadRes.Name,
+		Ext:            profile.Ext,
+		Meta:           meta,
+		SourceDuration: video.Duration,
+		Stream:         audioStream,
+	}
+	if opts.Clip.IsZero() {
+		result.Chapters = parseChapters(video.Description, video.Duration)
+	}
+	return result, nil
 
 }
 
-func (s *Service) DownloadVideo(ctx context.Context, link string) (app.DownloadResult, error) {
-	//TODO implement me
-	panic("implement me")
+// DownloadVideo downloads video-only and audio-only formats of the video and muxes them into single MP4 stream.
+// Video format is specified by opts.Itag, otherwise the best one is chosen. If the specified format already
+// contains audio, it's returned as is unless the clip is requested.
+func (s *Service) DownloadVideo(ctx context.Context, link string, opts app.DownloadOptions) (result app.DownloadResult, err error) {
+	ctx = logging.NewContextS(ctx, zap.String("video_link", link))
+	ytClient := new(youtube.Client)
+
+	video, err := s.getVideo(ctx, ytClient, link)
+	if err != nil {
+		return app.DownloadResult{}, err
+	}
+	if err := validateClip(opts.Clip, video.Duration); err != nil {
+		return app.DownloadResult{}, err
+	}
+	var videoFormat *youtube.Format
+	if opts.Itag != 0 {
+		formats := video.Formats.Itag(opts.Itag).Type(videoMP4PatternMime)
+		if len(formats) == 0 {
+			return app.DownloadResult{}, fmt.Errorf("no video format found for itag %d and type pattern %q", opts.Itag, videoMP4PatternMime)
+		}
+		videoFormat = &formats[0]
+	} else {
+		videoFormat, err = selectVideoOnlyFormat(video.Formats, defaultVideoMaxHeight)
+		if err != nil {
+			return app.DownloadResult{}, err
+		}
+	}
+	result = app.DownloadResult{
+		Name:           video.Title,
+		Ext:            "mp4",
+		SourceDuration: video.Duration,
+	}
+	videoStream, videoLen, err := s.openStream(ctx, ytClient, video, videoFormat, opts.Progress)
+	if err != nil {
+		return app.DownloadResult{}, fmt.Errorf("failed to start downloading video stream: %w", err)
+	}
+	if videoFormat.AudioChannels > 0 {
+		result.ContentLen = videoLen
+		if opts.Clip.IsZero() {
+			result.Stream = videoStream
+			return result, nil
+		}
+		result.Stream, err = s.muxToMP4(ctx, videoStream, nil, opts.Clip)
+		if err != nil {
+			_ = videoStream.Close()
+			return app.DownloadResult{}, fmt.Errorf("failed to cut clip from mp4: %w", err)
+		}
+		return result, nil
+	}
+	audioFormat, err := selectAudioOnlyFormat(video.Formats)
+	if err != nil {
+		_ = videoStream.Close()
+		return app.DownloadResult{}, err
+	}
+	audioStream, audioLen, err := s.openStream(ctx, ytClient, video, audioFormat, opts.Progress)
+	if err != nil {
+		_ = videoStream.Close()
+		return app.DownloadResult{}, fmt.Errorf("failed to start downloading audio stream: %w", err)
+	}
+
+	result.ContentLen = videoLen + audioLen
+	result.Stream, err = s.muxToMP4(ctx, videoStream, audioStream, opts.Clip)
+	if err != nil {
+		_ = videoStream.Close()
+		_ = audioStream.Close()
+		return app.DownloadResult{}, fmt.Errorf("failed to mux video and audio to mp4: %w", err)
+	}
+	return result, nil
 }
 
-func (s *Service) downloadStream(ctx context.Context, link string, formatType string) (result app.DownloadResult, err error) {
-	log := logging.FromContextS(ctx)
+// downloadStream starts downloading of the format with audio. If itag is zero, the first MP4 audio format is used.
+// The format must be supported by the audio profile.
+func (s *Service) downloadStream(ctx context.Context, link string, opts app.DownloadOptions, profile AudioProfile) (result app.DownloadResult, video *youtube.Video, err error) {
 	ytClient := new(youtube.Client)
 
-	link = s.transformLink(ctx, link)
-	video, err := ytClient.GetVideo(link)
+	video, err = s.getVideo(ctx, ytClient, link)
 	if err != nil {
-		return app.DownloadResult{}, fmt.Errorf("failed to get video by link: %w", err)
+		return app.DownloadResult{}, nil, err
+	}
+	if err := validateClip(opts.Clip, video.Duration); err != nil {
+		return app.DownloadResult{}, nil, err
+	}
+	itag := opts.Itag
+	formats := video.Formats.WithAudioChannels()
+	if itag != 0 {
+		formats = formats.Itag(itag)
+	} else {
+		formats = formats.Type(audioMP4PatternMime)
 	}
-	log.Infof("Got video metadata with %d formats", len(video.Formats))
-	formats := video.Formats.WithAudioChannels().Type(formatType)
 	if len(formats) == 0 {
-		return app.DownloadResult{}, fmt.Errorf("no video format found for type pattern %q", formatType)
+		return app.DownloadResult{}, nil, fmt.Errorf("no audio format found for itag %d and type pattern %q", itag, audioMP4PatternMime)
+	}
+	if !profile.SupportsSource(formats[0].MimeType) {
+		return app.DownloadResult{}, nil, fmt.Errorf("audio profile %q doesn't support source format %q", profile.Name, formats[0].MimeType)
+	}
+	stream, contentLen, err := s.openStream(ctx, ytClient, video, &formats[0], opts.Progress)
+	if err != nil {
+		return app.DownloadResult{}, nil, err
+	}
+
+	return app.DownloadResult{
+		ContentLen: contentLen,
+		Name:       video.Title,
+		Stream:     stream,
+	}, video, err
+}
+
+func (s *Service) getVideo(ctx context.Context, ytClient *youtube.Client, link string) (*youtube.Video, error) {
+	log := logging.FromContextS(ctx)
+	link = s.transformLink(ctx, link)
+	video, err := ytClient.GetVideoContext(ctx, link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get video by link: %w", err)
 	}
-	format := &formats[0]
-	log.Infow("Found video format for pattern "+formatType,
+	log.Infof("Got video metadata with %d formats", len(video.Formats))
+	return video, nil
+}
+
+func (s *Service) openStream(ctx context.Context, ytClient *youtube.Client, video *youtube.Video, format *youtube.Format, progress io.Writer) (stream io.ReadCloser, contentLen int64, err error) {
+	log := logging.FromContextS(ctx)
+	log.Infow("Selected video format",
 		"format_url", format.URL,
 		"format_mime_type", format.MimeType,
 		"format_quality", format.Quality,
 		"format_itag", format.ItagNo,
 	)
-	stream, contentLen, err := ytClient.GetStreamContext(ctx, video, format)
+	stream, contentLen, err = s.getStream(ctx, ytClient, video, format, progress)
 	if err != nil {
-		return app.DownloadResult{}, fmt.Errorf("failed to get video stream: %w", err)
+		return nil, 0, fmt.Errorf("failed to get video stream: %w", err)
 	}
 	log.Infof("Started downloading stream. Content length is %d", contentLen)
-
-	return app.DownloadResult{
-		ContentLen: contentLen,
-		Name:       video.Title,
-		Stream:     stream,
-	}, err
+	return stream, contentLen, nil
 }
 
-func (s *Service) convertMP4ToMP3(ctx context.Context, mp4Stream io.ReadCloser) (mp3Stream io.ReadCloser, err error) {
+// transcodeAudio converts source stream with the profile and writes tags of meta into the output.
+// Only the clip is written to the output if it's not zero.
+// If cover is not empty, it's passed to ffmpeg via additional file descriptor and embedded as attached picture.
+func (s *Service) transcodeAudio(ctx context.Context, sourceStream io.ReadCloser, profile AudioProfile, meta app.MediaMeta, cover []byte, clip app.TimeRange) (audioStream io.ReadCloser, err error) {
 	log := logging.FromContextS(ctx)
-	log.Info("Converting from MP4 to MP3 via ffmpeg...")
-	ffmpegCmd := exec.CommandContext(ctx, "ffmpeg", "-i", "pipe:", "-f", "mp3", "-")
-	ffmpegCmd.Stdin = mp4Stream
+	log.Infof("Transcoding audio with profile %q via ffmpeg...", profile.Name)
+	args := []string{"-i", "pipe:"}
+	var coverR, coverW *os.File
+	if len(cover) > 0 {
+		coverR, coverW, err = os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cover pipe: %w", err)
+		}
+		args = append(args,
+			"-i", "pipe:3",
+			"-map", "0:a:0",
+			"-map", "1:v:0",
+			"-c:v", "mjpeg",
+			"-disposition:v", "attached_pic",
+			"-metadata:s:v", "title=Album cover",
+			"-metadata:s:v", "comment=Cover (front)",
+		)
+		if profile.Ext == "mp3" {
+			args = append(args, "-id3v2_version", "3")
+		}
+	}
+	args = append(args, clipArgs(clip)...)
+	args = append(args, profile.ffmpegArgs...)
+	args = append(args, metadataArgs(meta)...)
+	args = append(args, "-")
+	ffmpegCmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	ffmpegCmd.Stdin = sourceStream
+	if coverR != nil {
+		ffmpegCmd.ExtraFiles = []*os.File{coverR}
+	}
 
-	mp3Stream, err = ffmpegCmd.StdoutPipe()
+	audioStream, err = ffmpegCmd.StdoutPipe()
 	if err != nil {
+		if coverR != nil {
+			closeFiles(coverR, coverW)
+		}
 		return nil, fmt.Errorf("failed to get ffmpeg stdout pipe: %w", err)
 	}
+	startedAt := time.Now()
 	if err := ffmpegCmd.Start(); err != nil {
+		if coverR != nil {
+			closeFiles(coverR, coverW)
+		}
 		return nil, fmt.Errorf("failed to start ffmpeg cmd: %w", err)
 	}
+	if coverR != nil {
+		_ = coverR.Close()
+		go func() {
+			defer coverW.Close()
+			if _, err := coverW.Write(cover); err != nil {
+				log.Errorf("ffmpeg: failed to write cover: %v", err)
+			}
+		}()
+	}
 	log.Info("ffmpeg converter started! Waiting...")
-	go func() {
-		defer mp4Stream.Close()
-		// TODO: В доке пишут что Wait не нужно вызывать до того, как все прочитают из Reader, но вроде работает все
-		if err := ffmpegCmd.Wait(); err != nil {
+	// ffmpeg is waited after its output is read, otherwise Wait may close stdout before the last bytes are read.
+	return newProcessStream(ffmpegCmd, audioStream, func(err error) {
+		metrics.FFmpegDuration.WithLabelValues("transcode").Observe(time.Since(startedAt).Seconds())
+		_ = sourceStream.Close()
+		if err != nil {
 			log.Errorf("ffmpeg: An error occurred while Wait: %v", err)
 		}
 		log.Info("ffmpeg converter done!")
-	}()
+	}), nil
+}
 
-	return mp3Stream, nil
+// muxToMP4 merges separate video and audio streams into fragmented MP4 without re-encoding.
+// If audioStream is nil, video stream must already contain audio, and it's only remuxed, e.g. to cut the clip.
+// Streams are passed to ffmpeg as additional file descriptors, because it can read only one input from stdin.
+func (s *Service) muxToMP4(ctx context.Context, videoStream, audioStream io.ReadCloser, clip app.TimeRange) (mp4Stream io.ReadCloser, err error) {
+	log := logging.FromContextS(ctx)
+	log.Info("Muxing video and audio to MP4 via ffmpeg...")
+	inputs := []io.ReadCloser{videoStream}
+	if audioStream != nil {
+		inputs = append(inputs, audioStream)
+	}
+	var (
+		readers []*os.File
+		writers []*os.File
+		args    []string
+	)
+	closePipes := func() {
+		closeFiles(readers...)
+		closeFiles(writers...)
+	}
+	for i := range inputs {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closePipes()
+			return nil, fmt.Errorf("failed to create pipe for input %d: %w", i, err)
+		}
+		readers = append(readers, r)
+		writers = append(writers, w)
+		// ExtraFiles[i] becomes file descriptor 3+i in ffmpeg process.
+		args = append(args, "-i", fmt.Sprintf("pipe:%d", 3+i))
+	}
+	if audioStream != nil {
+		args = append(args, "-map", "0:v:0", "-map", "1:a:0")
+	}
+	args = append(args, "-c", "copy")
+	args = append(args, clipArgs(clip)...)
+	args = append(args,
+		"-movflags", "frag_keyframe+empty_moov",
+		"-f", "mp4",
+		"-",
+	)
+	ffmpegCmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	ffmpegCmd.ExtraFiles = readers
+
+	mp4Stream, err = ffmpegCmd.StdoutPipe()
+	if err != nil {
+		closePipes()
+		return nil, fmt.Errorf("failed to get ffmpeg stdout pipe: %w", err)
+	}
+	startedAt := time.Now()
+	if err := ffmpegCmd.Start(); err != nil {
+		closePipes()
+		return nil, fmt.Errorf("failed to start ffmpeg cmd: %w", err)
+	}
+	// Read ends are inherited by ffmpeg now, so we must close our copies to get EOF handling right.
+	closeFiles(readers...)
+
+	copyToPipe := func(idx int, w *os.File, r io.ReadCloser) {
+		defer w.Close()
+		defer r.Close()
+		if _, err := io.Copy(w, r); err != nil {
+			log.Errorf("ffmpeg: failed to copy input %d: %v", idx, err)
+		}
+	}
+	for i, input := range inputs {
+		go copyToPipe(i, writers[i], input)
+	}
+
+	log.Info("ffmpeg muxer started! Waiting...")
+	return newProcessStream(ffmpegCmd, mp4Stream, func(err error) {
+		metrics.FFmpegDuration.WithLabelValues("mux").Observe(time.Since(startedAt).Seconds())
+		if err != nil {
+			log.Errorf("ffmpeg: An error occurred while Wait: %v", err)
+		}
+		log.Info("ffmpeg muxer done!")
+	}), nil
+}
+
+func closeFiles(files ...*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
 }
 
 // transformLink extracts and returns video id if link has '/live/' path.
@@ -0,0 +1,323 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+	"github.com/vm-affekt/tgytbot/internal/metrics"
+)
+
+// SplitByChapters saves the whole audio to temporary file and cuts it into one file per chapter without re-encoding.
+// Each part gets its own title and track number tags.
+func (s *Service) SplitByChapters(ctx context.Context, stream io.Reader, res app.DownloadResult) <-chan app.MediaPart {
+	parts := make(chan app.MediaPart)
+	go func() {
+		defer close(parts)
+		log := logging.FromContextS(ctx)
+		sendErr := func(err error) {
+			select {
+			case parts <- app.MediaPart{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+
+		srcPath, err := saveToTempFile(stream, res.Ext)
+		if err != nil {
+			sendErr(fmt.Errorf("failed to save audio to temporary file: %w", err))
+			return
+		}
+		defer os.Remove(srcPath)
+		log.Infof("Audio is saved to %q. Cutting %d chapters...", srcPath, len(res.Chapters))
+
+		for i, chapter := range res.Chapters {
+			meta := res.Meta
+			meta.Title = chapter.Title
+			meta.Duration = chapter.Duration(res.SourceDuration)
+			extraArgs := []string{"-metadata", fmt.Sprintf("track=%d/%d", i+1, len(res.Chapters))}
+			part, err := s.cutPart(ctx, srcPath, res.Ext, chapter.TimeRange, meta, extraArgs)
+			if err != nil {
+				sendErr(fmt.Errorf("failed to cut chapter %d %q: %w", i+1, chapter.Title, err))
+				return
+			}
+			part.Num = i + 1
+			part.Total = len(res.Chapters)
+			part.Name = chapter.Title
+			select {
+			case parts <- part:
+			case <-ctx.Done():
+				_ = part.Stream.Close()
+				return
+			}
+		}
+	}()
+	return parts
+}
+
+// cutPart copies the range of the source file to the new temporary file and writes meta to its tags.
+func (s *Service) cutPart(ctx context.Context, srcPath string, ext string, r app.TimeRange, meta app.MediaMeta, extraArgs []string) (app.MediaPart, error) {
+	out, err := os.CreateTemp("", "tgytbot-part-*."+ext)
+	if err != nil {
+		return app.MediaPart{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	outPath := out.Name()
+	_ = out.Close()
+
+	args := []string{"-y", "-ss", formatFFmpegTime(r.Start)}
+	if r.End > r.Start {
+		args = append(args, "-t", formatFFmpegTime(r.End-r.Start))
+	}
+	args = append(args, "-i", srcPath, "-map", "0", "-c", "copy")
+	args = append(args, metadataArgs(meta)...)
+	args = append(args, extraArgs...)
+	args = append(args, outPath)
+	startedAt := time.Now()
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	metrics.FFmpegDuration.WithLabelValues("cut").Observe(time.Since(startedAt).Seconds())
+	if err != nil {
+		_ = os.Remove(outPath)
+		return app.MediaPart{}, fmt.Errorf("ffmpeg failed: %w, output: %s", err, lastBytes(output, 512))
+	}
+
+	f, err := os.Open(outPath)
+	if err != nil {
+		_ = os.Remove(outPath)
+		return app.MediaPart{}, fmt.Errorf("failed to open part file: %w", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(outPath)
+		return app.MediaPart{}, fmt.Errorf("failed to stat part file: %w", err)
+	}
+	return app.MediaPart{
+		Meta:   meta,
+		Size:   stat.Size(),
+		Stream: &tempFile{File: f},
+	}, nil
+}
+
+func saveToTempFile(stream io.Reader, ext string) (path string, err error) {
+	f, err := os.CreateTemp("", "tgytbot-src-*."+ext)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, stream); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// tempFile removes the file on Close.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+func lastBytes(b []byte, n int) string {
+	if len(b) > n {
+		b = b[len(b)-n:]
+	}
+	return strconv.Quote(string(b))
+}
+
+const (
+	// partSizeReserve leaves room for container overhead and bitrate fluctuations, so parts don't exceed the limit.
+	partSizeReserve = 0.93
+	// silenceSearchWindow is the share of part duration before the estimated cut point where silence is looked for.
+	silenceSearchWindow = 0.15
+)
+
+var (
+	silenceStartRegexp = regexp.MustCompile(`silence_start: (-?[\d.]+)`)
+	silenceEndRegexp   = regexp.MustCompile(`silence_end: (-?[\d.]+)`)
+	durationRegexp     = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+)
+
+// SplitAtSilence saves the whole audio to temporary file and cuts it into parts not bigger than maxPartSize bytes.
+// The audio which fits maxPartSize is sent as is in one part.
+// Part duration is estimated from the average bitrate of the file. Each cut is moved to the nearest quiet point
+// found by ffmpeg's silencedetect filter before the estimated point, so parts don't break in the middle of a word.
+func (s *Service) SplitAtSilence(ctx context.Context, stream io.Reader, res app.DownloadResult, maxPartSize int64) <-chan app.MediaPart {
+	parts := make(chan app.MediaPart)
+	go func() {
+		defer close(parts)
+		log := logging.FromContextS(ctx)
+		sendErr := func(err error) {
+			select {
+			case parts <- app.MediaPart{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+
+		srcPath, err := saveToTempFile(stream, res.Ext)
+		if err != nil {
+			sendErr(fmt.Errorf("failed to save audio to temporary file: %w", err))
+			return
+		}
+		f, err := os.Open(srcPath)
+		if err != nil {
+			_ = os.Remove(srcPath)
+			sendErr(fmt.Errorf("failed to open audio file: %w", err))
+			return
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(srcPath)
+			sendErr(fmt.Errorf("failed to stat audio file: %w", err))
+			return
+		}
+		if stat.Size() <= maxPartSize {
+			log.Infof("Audio of %.2f MB fits the limit, it's sent without splitting", float64(stat.Size())/(1<<20))
+			part := app.MediaPart{
+				Num:    1,
+				Total:  1,
+				Name:   res.Name,
+				Meta:   res.Meta,
+				Size:   stat.Size(),
+				Stream: &tempFile{File: f},
+			}
+			select {
+			case parts <- part:
+			case <-ctx.Done():
+				_ = part.Stream.Close()
+			}
+			return
+		}
+		_ = f.Close()
+		defer os.Remove(srcPath)
+
+		silences, probedDuration, err := s.detectSilences(ctx, srcPath)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		duration := res.Meta.Duration
+		if probedDuration > 0 {
+			duration = probedDuration
+		}
+		if duration <= 0 {
+			sendErr(fmt.Errorf("duration of audio %q is unknown", srcPath))
+			return
+		}
+		bytesPerSecond := float64(stat.Size()) / duration.Seconds()
+		partDuration := time.Duration(float64(maxPartSize) * partSizeReserve / bytesPerSecond * float64(time.Second))
+		ranges := splitRanges(duration, partDuration, silences)
+		log.Infof("Audio of %.2f MB and %v is split into %d parts at %d detected silences",
+			float64(stat.Size())/(1<<20), duration, len(ranges), len(silences))
+
+		for i, r := range ranges {
+			meta := res.Meta
+			meta.Duration = r.Duration(duration)
+			if len(ranges) > 1 {
+				meta.Title = fmt.Sprintf("%s (%d/%d)", res.Meta.Title, i+1, len(ranges))
+			}
+			extraArgs := []string{"-metadata", fmt.Sprintf("track=%d/%d", i+1, len(ranges))}
+			part, err := s.cutPart(ctx, srcPath, res.Ext, r, meta, extraArgs)
+			if err != nil {
+				sendErr(fmt.Errorf("failed to cut part %d: %w", i+1, err))
+				return
+			}
+			part.Num = i + 1
+			part.Total = len(ranges)
+			part.Name = res.Name
+			select {
+			case parts <- part:
+			case <-ctx.Done():
+				_ = part.Stream.Close()
+				return
+			}
+		}
+	}()
+	return parts
+}
+
+// detectSilences returns middle points of silent intervals of the audio file and its duration if ffmpeg reports it.
+func (s *Service) detectSilences(ctx context.Context, srcPath string) (silences []time.Duration, duration time.Duration, err error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-i", srcPath,
+		"-af", "silencedetect=noise=-35dB:d=0.4",
+		"-f", "null", "-",
+	)
+	startedAt := time.Now()
+	output, err := cmd.CombinedOutput()
+	metrics.FFmpegDuration.WithLabelValues("silencedetect").Observe(time.Since(startedAt).Seconds())
+	if err != nil {
+		return nil, 0, fmt.Errorf("ffmpeg silencedetect failed: %w, output: %s", err, lastBytes(output, 512))
+	}
+	silences, duration = parseSilenceDetectOutput(string(output))
+	return silences, duration, nil
+}
+
+func parseSilenceDetectOutput(output string) (silences []time.Duration, duration time.Duration) {
+	if m := durationRegexp.FindStringSubmatch(output); m != nil {
+		h, _ := strconv.Atoi(m[1])
+		mins, _ := strconv.Atoi(m[2])
+		sec, _ := strconv.ParseFloat(m[3], 64)
+		duration = time.Duration(h)*time.Hour + time.Duration(mins)*time.Minute + secondsToDuration(sec)
+	}
+	starts := silenceStartRegexp.FindAllStringSubmatch(output, -1)
+	ends := silenceEndRegexp.FindAllStringSubmatch(output, -1)
+	for i := 0; i < len(starts) && i < len(ends); i++ {
+		start, err1 := strconv.ParseFloat(starts[i][1], 64)
+		end, err2 := strconv.ParseFloat(ends[i][1], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if start < 0 {
+			start = 0
+		}
+		silences = append(silences, secondsToDuration((start+end)/2))
+	}
+	return silences, duration
+}
+
+// splitRanges cuts the audio of total duration into ranges not longer than partDuration. Each cut is made
+// at the latest silence within the search window before the estimated point, or exactly at the point if
+// there is no silence there. Silences must be sorted.
+func splitRanges(total, partDuration time.Duration, silences []time.Duration) []app.TimeRange {
+	if partDuration <= 0 || total <= partDuration {
+		return []app.TimeRange{{Start: 0, End: total}}
+	}
+	window := time.Duration(float64(partDuration) * silenceSearchWindow)
+	var (
+		ranges []app.TimeRange
+		start  time.Duration
+	)
+	for total-start > partDuration {
+		target := start + partDuration
+		cut := target
+		for i := len(silences) - 1; i >= 0; i-- {
+			if silences[i] > target {
+				continue
+			}
+			if silences[i] >= target-window && silences[i] > start {
+				cut = silences[i]
+			}
+			break
+		}
+		ranges = append(ranges, app.TimeRange{Start: start, End: cut})
+		start = cut
+	}
+	return append(ranges, app.TimeRange{Start: start, End: total})
+}
+
+func secondsToDuration(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+func Test_parseSilenceDetectOutput(t *testing.T) {
+	output := `Input #0, mp3, from '/tmp/tgytbot-src-1.mp3':
+  Duration: 00:10:05.50, start: 0.025057, bitrate: 192 kb/s
+[silencedetect @ 0x55d5c2a0] silence_start: -0.01
+[silencedetect @ 0x55d5c2a0] silence_end: 1.5 | silence_duration: 1.51
+[silencedetect @ 0x55d5c2a0] silence_start: 290
+[silencedetect @ 0x55d5c2a0] silence_end: 291 | silence_duration: 1
+size=N/A time=00:10:05.50 bitrate=N/A speed= 512x`
+	silences, duration := parseSilenceDetectOutput(output)
+	wantSilences := []time.Duration{750 * time.Millisecond, 290500 * time.Millisecond}
+	if !reflect.DeepEqual(silences, wantSilences) {
+		t.Errorf("parseSilenceDetectOutput() silences = %v, want %v", silences, wantSilences)
+	}
+	if wantDuration := 10*time.Minute + 5500*time.Millisecond; duration != wantDuration {
+		t.Errorf("parseSilenceDetectOutput() duration = %v, want %v", duration, wantDuration)
+	}
+}
+
+func Test_splitRanges(t *testing.T) {
+	tests := []struct {
+		name         string
+		total        time.Duration
+		partDuration time.Duration
+		silences     []time.Duration
+		want         []app.TimeRange
+	}{
+		{
+			name:         "should_return_whole_audio_when_it_fits",
+			total:        5 * time.Minute,
+			partDuration: 10 * time.Minute,
+			want:         []app.TimeRange{{Start: 0, End: 5 * time.Minute}},
+		},
+		{
+			name:         "should_cut_at_latest_silence_within_window",
+			total:        25 * time.Minute,
+			partDuration: 10 * time.Minute,
+			silences:     []time.Duration{8 * time.Minute, 9 * time.Minute, 11 * time.Minute, 18*time.Minute + 30*time.Second},
+			want: []app.TimeRange{
+				{Start: 0, End: 9 * time.Minute},
+				{Start: 9 * time.Minute, End: 18*time.Minute + 30*time.Second},
+				{Start: 18*time.Minute + 30*time.Second, End: 25 * time.Minute},
+			},
+		},
+		{
+			name:         "should_cut_at_estimated_point_without_silence_nearby",
+			total:        15 * time.Minute,
+			partDuration: 10 * time.Minute,
+			silences:     []time.Duration{2 * time.Minute},
+			want: []app.TimeRange{
+				{Start: 0, End: 10 * time.Minute},
+				{Start: 10 * time.Minute, End: 15 * time.Minute},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitRanges(tt.total, tt.partDuration, tt.silences)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
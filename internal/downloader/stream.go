@@ -0,0 +1,334 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+const streamUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36"
+
+// StreamOptions configures downloading of streams by chunks.
+type StreamOptions struct {
+	// ChunkSize is the size of Range request in bytes.
+	ChunkSize int64
+	// Concurrency is the number of chunks downloaded at the same time. Every chunk is kept in memory
+	// until all previous chunks are read, so up to Concurrency*ChunkSize bytes are buffered.
+	Concurrency int
+	// MaxRetries is the number of attempts to download a chunk after the first failed one.
+	// Attempts are counted again when some bytes of the chunk are received.
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry. It's doubled on every next retry up to RetryMaxDelay.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+// DefaultStreamOptions returns the options used when they aren't configured.
+func DefaultStreamOptions() StreamOptions {
+	return StreamOptions{
+		// Downloading in multiple chunks is much faster:
+		// https://github.com/kkdai/youtube/pull/190
+		ChunkSize:      10 << 20,
+		Concurrency:    4,
+		MaxRetries:     5,
+		RetryBaseDelay: time.Second,
+		RetryMaxDelay:  30 * time.Second,
+	}
+}
+
+// ErrUnexpectedStatusCode is returned on unexpected HTTP status codes
+type ErrUnexpectedStatusCode int
+
+func (err ErrUnexpectedStatusCode) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", err)
+}
+
+// getStream returns the stream and the total size for a specific format.
+// If the length of format is known, it's downloaded by chunks which are retried on failures.
+// Received bytes are also written to progress if it's not nil.
+func (s *Service) getStream(ctx context.Context, ytClient *youtube.Client, video *youtube.Video, format *youtube.Format, progress io.Writer) (io.ReadCloser, int64, error) {
+	url, err := ytClient.GetStreamURLContext(ctx, video, format)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get stream url: %w", err)
+	}
+	httpClient := ytClient.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if format.ContentLength == 0 {
+		// some videos don't have length information
+		return downloadOnce(ctx, httpClient, url, progress)
+	}
+	refreshURL := func(ctx context.Context) (string, error) {
+		return refreshStreamURL(ctx, ytClient, video.ID, format)
+	}
+	return newChunkedStream(ctx, httpClient, url, format.ContentLength, refreshURL, progress, s.streamOpts), format.ContentLength, nil
+}
+
+// refreshStreamURL gets the video metadata again and returns new url of the same format.
+// It's used when the signature of the url is expired.
+func refreshStreamURL(ctx context.Context, ytClient *youtube.Client, videoID string, format *youtube.Format) (string, error) {
+	video, err := ytClient.GetVideoContext(ctx, videoID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get video: %w", err)
+	}
+	for _, f := range video.Formats.Itag(format.ItagNo) {
+		if f.MimeType == format.MimeType {
+			return ytClient.GetStreamURLContext(ctx, video, &f)
+		}
+	}
+	return "", fmt.Errorf("format with itag %d and type %q disappeared from video", format.ItagNo, format.MimeType)
+}
+
+func downloadOnce(ctx context.Context, httpClient *http.Client, url string, progress io.Writer) (io.ReadCloser, int64, error) {
+	req, err := newStreamRequest(ctx, url)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, 0, ErrUnexpectedStatusCode(resp.StatusCode)
+	}
+	contentLen, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if progress == nil {
+		return resp.Body, contentLen, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.TeeReader(resp.Body, progress),
+		Closer: resp.Body,
+	}, contentLen, nil
+}
+
+func newStreamRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", streamUserAgent)
+	req.Header.Set("Origin", "https://youtube.com")
+	req.Header.Set("Sec-Fetch-Mode", "navigate")
+	return req, nil
+}
+
+// chunkedDownloader writes the stream of known length to the pipe by Range requests.
+// Chunks are downloaded by concurrent workers and written in order of offsets.
+// Failed chunk is requested again from the last received byte.
+type chunkedDownloader struct {
+	httpClient *http.Client
+	contentLen int64
+	refreshURL func(ctx context.Context) (string, error)
+	progress   io.Writer
+	opts       StreamOptions
+
+	urlMu sync.Mutex
+	url   string
+}
+
+// newChunkedStream starts downloading of the stream. Received bytes are also written to progress if it's not nil.
+func newChunkedStream(ctx context.Context, httpClient *http.Client, url string, contentLen int64, refreshURL func(ctx context.Context) (string, error), progress io.Writer, opts StreamOptions) io.ReadCloser {
+	r, w := io.Pipe()
+	d := &chunkedDownloader{
+		httpClient: httpClient,
+		url:        url,
+		contentLen: contentLen,
+		refreshURL: refreshURL,
+		progress:   progress,
+		opts:       opts,
+	}
+	go func() {
+		//nolint:errcheck
+		w.CloseWithError(d.run(ctx, w))
+	}()
+	return r
+}
+
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+func (d *chunkedDownloader) run(ctx context.Context, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	concurrency := d.opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// Results of chunks are queued in order of offsets. The capacity of the queue limits memory usage:
+	// there are at most concurrency chunks including the one which is being written.
+	pending := make(chan chan chunkResult, concurrency-1)
+	go func() {
+		defer close(pending)
+		for pos := int64(0); pos < d.contentLen; pos += d.opts.ChunkSize {
+			result := make(chan chunkResult, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+			go func(pos int64) {
+				data, err := d.loadChunk(ctx, pos)
+				result <- chunkResult{data: data, err: err}
+			}(pos)
+		}
+	}()
+	for result := range pending {
+		res := <-result
+		if res.err != nil {
+			return res.err
+		}
+		if _, err := w.Write(res.data); err != nil {
+			return err
+		}
+	}
+	// Chunks aren't queued anymore when the context is done, so the stream is incomplete.
+	return ctx.Err()
+}
+
+// loadChunk downloads the chunk starting at pos. It's retried until the limit of attempts is reached.
+func (d *chunkedDownloader) loadChunk(ctx context.Context, pos int64) ([]byte, error) {
+	log := logging.FromContextS(ctx)
+	end := pos + d.opts.ChunkSize
+	if end > d.contentLen {
+		end = d.contentLen
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, end-pos))
+	var attempt int
+	for {
+		url := d.currentURL()
+		offset := pos + int64(buf.Len())
+		written, err := d.requestRange(ctx, url, buf, offset, end)
+		if err == nil {
+			return buf.Bytes(), nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		offset += written
+		if !isRetryable(err) {
+			return nil, fmt.Errorf("failed to download chunk at offset %d: %w", offset, err)
+		}
+		if written > 0 {
+			attempt = 0
+		}
+		attempt++
+		if attempt > d.opts.MaxRetries {
+			return nil, fmt.Errorf("failed to download chunk at offset %d after %d attempts: %w", offset, attempt, err)
+		}
+		var statusErr ErrUnexpectedStatusCode
+		if errors.As(err, &statusErr) && statusErr == http.StatusForbidden {
+			log.Warnw("Stream url is forbidden, probably it's expired. Refreshing url...", "offset", offset)
+			if err := d.refresh(ctx, url); err != nil {
+				return nil, err
+			}
+		}
+		delay := d.backoff(attempt)
+		log.Warnw("Failed to download chunk. Retrying...",
+			"offset", offset,
+			"attempt", attempt,
+			"delay", delay,
+			"error", err,
+		)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// requestRange downloads bytes from pos to end (exclusive) and returns the number of bytes written to w.
+func (d *chunkedDownloader) requestRange(ctx context.Context, url string, w io.Writer, pos, end int64) (int64, error) {
+	req, err := newStreamRequest(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", pos, end-1))
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, ErrUnexpectedStatusCode(resp.StatusCode)
+	}
+	if d.progress != nil {
+		w = io.MultiWriter(w, d.progress)
+	}
+	written, err := io.Copy(w, io.LimitReader(resp.Body, end-pos))
+	if err == nil && written < end-pos {
+		err = io.ErrUnexpectedEOF
+	}
+	return written, err
+}
+
+func (d *chunkedDownloader) currentURL() string {
+	d.urlMu.Lock()
+	defer d.urlMu.Unlock()
+	return d.url
+}
+
+// refresh replaces the url which is failed. Other workers could fail with the same url at the same time,
+// so the url is refreshed only once.
+func (d *chunkedDownloader) refresh(ctx context.Context, failedURL string) error {
+	d.urlMu.Lock()
+	defer d.urlMu.Unlock()
+	if d.url != failedURL {
+		return nil
+	}
+	url, err := d.refreshURL(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to refresh stream url: %w", err)
+	}
+	d.url = url
+	return nil
+}
+
+// backoff returns exponential delay with jitter, so many downloads failed at once don't retry simultaneously.
+func (d *chunkedDownloader) backoff(attempt int) time.Duration {
+	delay := d.opts.RetryBaseDelay
+	for i := 1; i < attempt && delay < d.opts.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.opts.RetryMaxDelay {
+		delay = d.opts.RetryMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// isRetryable reports whether the chunk can be downloaded successfully on the next attempt.
+// Network errors are always retried, but most of client errors are not.
+func isRetryable(err error) bool {
+	var statusErr ErrUnexpectedStatusCode
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	switch {
+	case statusErr == http.StatusForbidden, statusErr == http.StatusRequestTimeout, statusErr == http.StatusTooManyRequests:
+		return true
+	case statusErr >= 500:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,240 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/logging"
+	"go.uber.org/zap"
+)
+
+// rangeServer serves content by Range requests. The fail function can break the response of some requests.
+type rangeServer struct {
+	content []byte
+	// fail is called with the number of request starting from 1 and returns the status code to respond with,
+	// or -1 when the half of the range must be sent before breaking the connection.
+	fail func(n int, r *http.Request) int
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.Path+" "+r.Header.Get("Range"))
+	n := len(s.requests)
+	s.mu.Unlock()
+
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	chunk := s.content[start : end+1]
+	status := 0
+	if s.fail != nil {
+		status = s.fail(n, r)
+	}
+	switch {
+	case status == -1:
+		w.Header().Set("Content-Length", fmt.Sprint(len(chunk)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(chunk[:len(chunk)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	case status != 0:
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(chunk)))
+	w.WriteHeader(http.StatusPartialContent)
+	_, _ = w.Write(chunk)
+}
+
+func TestChunkedStream(t *testing.T) {
+	logging.SetLogger(zap.NewExample())
+	content := []byte(strings.Repeat("0123456789", 2) + "abcde")
+	opts := StreamOptions{
+		ChunkSize:      10,
+		Concurrency:    1,
+		MaxRetries:     2,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  5 * time.Millisecond,
+	}
+	tests := []struct {
+		name         string
+		fail         func(n int, r *http.Request) int
+		wantErr      error
+		wantRequests []string
+	}{
+		{
+			name:         "should_download_by_chunks",
+			wantRequests: []string{"/old bytes=0-9", "/old bytes=10-19", "/old bytes=20-24"},
+		},
+		{
+			name: "should_retry_failed_chunk",
+			fail: func(n int, r *http.Request) int {
+				if n == 2 || n == 3 {
+					return http.StatusServiceUnavailable
+				}
+				return 0
+			},
+			wantRequests: []string{"/old bytes=0-9", "/old bytes=10-19", "/old bytes=10-19", "/old bytes=10-19", "/old bytes=20-24"},
+		},
+		{
+			name: "should_resume_from_last_received_byte",
+			fail: func(n int, r *http.Request) int {
+				if n == 2 {
+					return -1
+				}
+				return 0
+			},
+			wantRequests: []string{"/old bytes=0-9", "/old bytes=10-19", "/old bytes=15-19", "/old bytes=20-24"},
+		},
+		{
+			name: "should_refresh_url_when_forbidden",
+			fail: func(n int, r *http.Request) int {
+				if r.URL.Path == "/old" && n > 1 {
+					return http.StatusForbidden
+				}
+				return 0
+			},
+			wantRequests: []string{"/old bytes=0-9", "/old bytes=10-19", "/new bytes=10-19", "/new bytes=20-24"},
+		},
+		{
+			name: "should_fail_after_max_retries",
+			fail: func(n int, r *http.Request) int {
+				if n > 1 {
+					return http.StatusInternalServerError
+				}
+				return 0
+			},
+			wantErr:      ErrUnexpectedStatusCode(http.StatusInternalServerError),
+			wantRequests: []string{"/old bytes=0-9", "/old bytes=10-19", "/old bytes=10-19", "/old bytes=10-19"},
+		},
+		{
+			name: "should_not_retry_client_error",
+			fail: func(n int, r *http.Request) int {
+				return http.StatusNotFound
+			},
+			wantErr:      ErrUnexpectedStatusCode(http.StatusNotFound),
+			wantRequests: []string{"/old bytes=0-9"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &rangeServer{content: content, fail: tt.fail}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+			refreshURL := func(ctx context.Context) (string, error) {
+				return ts.URL + "/new", nil
+			}
+
+			var progress bytes.Buffer
+			stream := newChunkedStream(context.Background(), ts.Client(), ts.URL+"/old", int64(len(content)), refreshURL, &progress, opts)
+			got, err := io.ReadAll(stream)
+			_ = stream.Close()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadAll() error = %v, want %v", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("ReadAll() error = %v", err)
+				}
+				if !bytes.Equal(got, content) {
+					t.Errorf("ReadAll() = %q, want %q", got, content)
+				}
+				if progress.Len() != len(content) {
+					t.Errorf("progress = %d bytes, want %d", progress.Len(), len(content))
+				}
+			}
+			if strings.Join(srv.requests, "; ") != strings.Join(tt.wantRequests, "; ") {
+				t.Errorf("requests = %q, want %q", srv.requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestChunkedStream_concurrent(t *testing.T) {
+	logging.SetLogger(zap.NewExample())
+	content := []byte(strings.Repeat("0123456789", 9) + "abcde")
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	srv := &rangeServer{content: content}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		// The first chunks are the slowest, so they're completed after the next ones.
+		var start int
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+		time.Sleep(time.Duration(len(content)-start) * time.Millisecond / 5)
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	opts := StreamOptions{
+		ChunkSize:   10,
+		Concurrency: 3,
+	}
+
+	stream := newChunkedStream(context.Background(), ts.Client(), ts.URL, int64(len(content)), nil, nil, opts)
+	got, err := io.ReadAll(stream)
+	_ = stream.Close()
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("ReadAll() = %q, want %q", got, content)
+	}
+	if len(srv.requests) != 10 {
+		t.Errorf("requests = %q, want 10 requests", srv.requests)
+	}
+	if maxFlight < 2 || maxFlight > opts.Concurrency {
+		t.Errorf("max concurrent requests = %d, want from 2 to %d", maxFlight, opts.Concurrency)
+	}
+}
+
+func TestChunkedDownloader_backoff(t *testing.T) {
+	d := &chunkedDownloader{opts: StreamOptions{
+		RetryBaseDelay: 100 * time.Millisecond,
+		RetryMaxDelay:  time.Second,
+	}}
+	tests := []struct {
+		attempt int
+		wantMax time.Duration
+	}{
+		{attempt: 1, wantMax: 100 * time.Millisecond},
+		{attempt: 2, wantMax: 200 * time.Millisecond},
+		{attempt: 4, wantMax: 800 * time.Millisecond},
+		{attempt: 5, wantMax: time.Second},
+		{attempt: 50, wantMax: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("should_grow_exponentially_on_attempt_%d", tt.attempt), func(t *testing.T) {
+			got := d.backoff(tt.attempt)
+			if got < tt.wantMax/2 || got > tt.wantMax {
+				t.Errorf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.wantMax/2, tt.wantMax)
+			}
+		})
+	}
+}
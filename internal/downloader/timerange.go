@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+var (
+	timeRangeRegexp = regexp.MustCompile(`(?i)^(?:from|с|от)?\s*([\d:]+)\s*(?:-|–|—|to|до|по)\s*([\d:]+)$`)
+	linkTimeRegexp  = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+)
+
+// ParseTimeRange parses time range like "1:20-4:05", "from 1:20 to 4:05" or "с 1:20 до 4:05".
+func ParseTimeRange(text string) (app.TimeRange, error) {
+	matches := timeRangeRegexp.FindStringSubmatch(strings.TrimSpace(text))
+	if matches == nil {
+		return app.TimeRange{}, fmt.Errorf("string %q is not a time range", text)
+	}
+	start, err := ParseTimestamp(matches[1])
+	if err != nil {
+		return app.TimeRange{}, fmt.Errorf("invalid start of range: %w", err)
+	}
+	end, err := ParseTimestamp(matches[2])
+	if err != nil {
+		return app.TimeRange{}, fmt.Errorf("invalid end of range: %w", err)
+	}
+	if end <= start {
+		return app.TimeRange{}, fmt.Errorf("end %v of range must be after start %v", end, start)
+	}
+	return app.TimeRange{Start: start, End: end}, nil
+}
+
+// ParseTimestamp parses timestamp like "1:02:03", "2:03" or "123".
+func ParseTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("too many parts in timestamp %q", s)
+	}
+	var total time.Duration
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number %q in timestamp %q", p, s)
+		}
+		if i > 0 && n >= 60 {
+			return 0, fmt.Errorf("minutes and seconds must be less than 60 in timestamp %q", s)
+		}
+		total = total*60 + time.Duration(n)
+	}
+	return total * time.Second, nil
+}
+
+// LinkStartTime returns the value of 't' query parameter of the link, e.g. "t=80", "t=80s" or "t=1m20s".
+func LinkStartTime(link string) (time.Duration, bool) {
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	parsedURL, err := url.Parse(link)
+	if err != nil {
+		return 0, false
+	}
+	t := parsedURL.Query().Get("t")
+	if t == "" {
+		return 0, false
+	}
+	matches := linkTimeRegexp.FindStringSubmatch(t)
+	if matches == nil {
+		return 0, false
+	}
+	var total time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, unit := range units {
+		if matches[i+1] == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(matches[i+1])
+		total += time.Duration(n) * unit
+	}
+	return total, total > 0
+}
+
+// validateClip checks that the clip is within the video of specified duration.
+func validateClip(clip app.TimeRange, duration time.Duration) error {
+	if clip.IsZero() {
+		return nil
+	}
+	if duration > 0 && clip.Start >= duration {
+		return fmt.Errorf("clip start %v is beyond the video duration %v", clip.Start, duration)
+	}
+	if clip.End != 0 && clip.End <= clip.Start {
+		return errors.New("clip end must be after its start")
+	}
+	return nil
+}
+
+// clipArgs returns ffmpeg output options which cut the clip.
+func clipArgs(clip app.TimeRange) []string {
+	if clip.IsZero() {
+		return nil
+	}
+	args := []string{"-ss", formatFFmpegTime(clip.Start)}
+	if clip.End != 0 {
+		args = append(args, "-to", formatFFmpegTime(clip.End))
+	}
+	return args
+}
+
+func formatFFmpegTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    app.TimeRange
+		wantErr bool
+	}{
+		{
+			name: "should_parse_dash_range",
+			text: "1:20-4:05",
+			want: app.TimeRange{Start: 80 * time.Second, End: 245 * time.Second},
+		},
+		{
+			name: "should_parse_english_range",
+			text: "from 1:20 to 4:05",
+			want: app.TimeRange{Start: 80 * time.Second, End: 245 * time.Second},
+		},
+		{
+			name: "should_parse_russian_range_with_hours",
+			text: "С 1:02:03 до 1:10:00",
+			want: app.TimeRange{Start: time.Hour + 2*time.Minute + 3*time.Second, End: time.Hour + 10*time.Minute},
+		},
+		{
+			name:    "should_return_err_when_end_before_start",
+			text:    "4:05 - 1:20",
+			wantErr: true,
+		},
+		{
+			name:    "should_return_err_on_invalid_seconds",
+			text:    "1:75 - 2:00",
+			wantErr: true,
+		},
+		{
+			name:    "should_return_err_on_text",
+			text:    "Статус",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTimeRange(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTimeRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkStartTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		link   string
+		want   time.Duration
+		wantOk bool
+	}{
+		{
+			name:   "should_parse_seconds",
+			link:   "https://youtu.be/GQtVIUdr4sk?t=80",
+			want:   80 * time.Second,
+			wantOk: true,
+		},
+		{
+			name:   "should_parse_units",
+			link:   "youtube.com/watch?v=7UxNoFjmhBA&t=1h2m3s",
+			want:   time.Hour + 2*time.Minute + 3*time.Second,
+			wantOk: true,
+		},
+		{
+			name: "should_return_false_without_param",
+			link: "https://www.youtube.com/watch?v=7UxNoFjmhBA",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := LinkStartTime(tt.link)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("LinkStartTime() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -3,9 +3,12 @@ package downloader
 import (
 	"fmt"
 	"github.com/kkdai/youtube/v2"
+	"regexp"
 	"strings"
 )
 
+var playlistIDRegexp = regexp.MustCompile(`[&?]list=([A-Za-z0-9_-]{13,42})(?:&|$)`)
+
 func ValidateLink(link string) error {
 	if !strings.Contains(link, "youtu.be/") && !strings.Contains(link, "youtube.com/") {
 		return fmt.Errorf("string %q doesn't contain youtube host", link)
@@ -16,3 +19,33 @@ func ValidateLink(link string) error {
 	}
 	return nil
 }
+
+// ExtractVideoID returns id of the video from link.
+func ExtractVideoID(link string) (string, error) {
+	if err := ValidateLink(link); err != nil {
+		return "", err
+	}
+	return youtube.ExtractVideoID(link)
+}
+
+// ExtractPlaylistID returns id of the playlist from link like "https://www.youtube.com/playlist?list=PL...".
+// Links to the video opened within the playlist contain the playlist id too.
+func ExtractPlaylistID(link string) (string, error) {
+	if !strings.Contains(link, "youtu.be/") && !strings.Contains(link, "youtube.com/") {
+		return "", fmt.Errorf("string %q doesn't contain youtube host", link)
+	}
+	matches := playlistIDRegexp.FindStringSubmatch(link)
+	if matches == nil {
+		return "", fmt.Errorf("link %q doesn't contain playlist id", link)
+	}
+	return matches[1], nil
+}
+
+// IsPlaylistLink returns true for the link to the playlist itself like "https://www.youtube.com/playlist?list=PL...".
+// Links to the video opened within the playlist are links to the video.
+func IsPlaylistLink(link string) bool {
+	if _, err := ExtractPlaylistID(link); err != nil {
+		return false
+	}
+	return strings.Contains(link, "/playlist?")
+}
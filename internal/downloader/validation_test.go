@@ -48,3 +48,78 @@ func TestValidateLink(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractPlaylistID(t *testing.T) {
+	tests := []struct {
+		name    string
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "should_extract_id_from_playlist_url",
+			link: "https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+			want: "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+		},
+		{
+			name: "should_extract_id_from_video_in_playlist_url",
+			link: "youtube.com/watch?v=7UxNoFjmhBA&list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI&index=2",
+			want: "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+		},
+		{
+			name:    "should_return_err_on_video_url",
+			link:    "https://www.youtube.com/watch?v=7UxNoFjmhBA",
+			wantErr: true,
+		},
+		{
+			name:    "should_return_err_on_other_host",
+			link:    "https://example.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractPlaylistID(tt.link)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractPlaylistID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ExtractPlaylistID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPlaylistLink(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want bool
+	}{
+		{
+			name: "should_return_true_on_playlist_url",
+			link: "https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+			want: true,
+		},
+		{
+			name: "should_return_true_on_playlist_url_without_scheme",
+			link: "youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+			want: true,
+		},
+		{
+			name: "should_return_false_on_video_in_playlist_url",
+			link: "https://www.youtube.com/watch?v=7UxNoFjmhBA&list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI&index=2",
+		},
+		{
+			name: "should_return_false_on_video_url",
+			link: "https://www.youtube.com/watch?v=7UxNoFjmhBA",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPlaylistLink(tt.link); got != tt.want {
+				t.Errorf("IsPlaylistLink() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
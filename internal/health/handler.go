@@ -0,0 +1,63 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// checkTimeout limits the time of all checks of one request.
+const checkTimeout = 5 * time.Second
+
+// Check is the named check of a component. Func returns an error if the component doesn't work.
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+}
+
+// Report is the response of Handler.
+type Report struct {
+	// Status is "ok" if all checks are passed and "fail" otherwise.
+	Status string `json:"status"`
+	// Checks contains "ok" or the error of each check by its name.
+	Checks map[string]string `json:"checks"`
+}
+
+// Handler runs the checks on every request. It responds with 200 if all checks are passed and with 503 otherwise.
+func Handler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+		report := Run(ctx, checks...)
+		code := http.StatusOK
+		if report.Status != statusOK {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// Run runs the checks one by one.
+func Run(ctx context.Context, checks ...Check) Report {
+	report := Report{
+		Status: statusOK,
+		Checks: make(map[string]string, len(checks)),
+	}
+	for _, c := range checks {
+		if err := c.Func(ctx); err != nil {
+			report.Status = statusFail
+			report.Checks[c.Name] = err.Error()
+			continue
+		}
+		report.Checks[c.Name] = statusOK
+	}
+	return report
+}
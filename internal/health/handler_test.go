@@ -0,0 +1,56 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	broken := func(context.Context) error { return errors.New("broken") }
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantCode   int
+		wantReport Report
+	}{
+		{
+			name:       "should_respond_ok_without_checks",
+			wantCode:   http.StatusOK,
+			wantReport: Report{Status: "ok", Checks: map[string]string{}},
+		},
+		{
+			name:       "should_respond_ok_when_all_checks_are_passed",
+			checks:     []Check{{Name: "a", Func: ok}, {Name: "b", Func: ok}},
+			wantCode:   http.StatusOK,
+			wantReport: Report{Status: "ok", Checks: map[string]string{"a": "ok", "b": "ok"}},
+		},
+		{
+			name:       "should_respond_unavailable_when_any_check_is_failed",
+			checks:     []Check{{Name: "a", Func: ok}, {Name: "b", Func: broken}},
+			wantCode:   http.StatusServiceUnavailable,
+			wantReport: Report{Status: "fail", Checks: map[string]string{"a": "ok", "b": "broken"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Handler(tt.checks...).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var report Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("failed to decode report: %v", err)
+			}
+			if !reflect.DeepEqual(report, tt.wantReport) {
+				t.Errorf("report = %+v, want %+v", report, tt.wantReport)
+			}
+		})
+	}
+}
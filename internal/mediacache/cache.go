@@ -0,0 +1,124 @@
+package mediacache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+// Cache is app.MediaCache keeping no more than maxEntries entries. Least recently used entries are evicted
+// when the cache is full. Entries older than maxAge are expired, because Telegram may drop old files.
+// All entries are kept in memory, storage is used to restore them after restart.
+type Cache struct {
+	storage    app.MediaCacheStorage
+	maxEntries int
+	maxAge     time.Duration
+	now        func() time.Time
+
+	mu sync.Mutex
+	// lru contains entries from the most recently used to the least recently used.
+	lru          *list.List
+	elementByKey map[string]*list.Element
+}
+
+// New loads entries from storage. Expired entries and entries exceeding maxEntries are removed.
+func New(ctx context.Context, storage app.MediaCacheStorage, maxEntries int, maxAge time.Duration) (*Cache, error) {
+	c := &Cache{
+		storage:      storage,
+		maxEntries:   maxEntries,
+		maxAge:       maxAge,
+		now:          time.Now,
+		lru:          list.New(),
+		elementByKey: make(map[string]*list.Element),
+	}
+	entries, err := storage.CachedMedia(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cached media: %w", err)
+	}
+	// Usage time isn't persisted, so the newest entries are treated as the most recently used.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CachedAt.After(entries[j].CachedAt)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, media := range entries {
+		if c.isExpired(media) || c.lru.Len() >= maxEntries {
+			if err := storage.DeleteCachedMedia(ctx, media.Key); err != nil {
+				return nil, fmt.Errorf("failed to delete stale media: %w", err)
+			}
+			continue
+		}
+		c.elementByKey[media.Key] = c.lru.PushBack(media)
+	}
+	return c, nil
+}
+
+func (c *Cache) Get(ctx context.Context, key string) (app.CachedMedia, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.elementByKey[key]
+	if !ok {
+		return app.CachedMedia{}, false, nil
+	}
+	media := el.Value.(app.CachedMedia)
+	if c.isExpired(media) {
+		if err := c.removeLocked(ctx, el); err != nil {
+			return app.CachedMedia{}, false, err
+		}
+		return app.CachedMedia{}, false, nil
+	}
+	c.lru.MoveToFront(el)
+	return media, true, nil
+}
+
+func (c *Cache) Put(ctx context.Context, media app.CachedMedia) error {
+	if media.CachedAt.IsZero() {
+		media.CachedAt = c.now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.storage.SaveCachedMedia(ctx, media); err != nil {
+		return fmt.Errorf("failed to save cached media: %w", err)
+	}
+	if el, ok := c.elementByKey[media.Key]; ok {
+		el.Value = media
+		c.lru.MoveToFront(el)
+		return nil
+	}
+	c.elementByKey[media.Key] = c.lru.PushFront(media)
+	for c.lru.Len() > c.maxEntries {
+		if err := c.removeLocked(ctx, c.lru.Back()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) Invalidate(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.elementByKey[key]
+	if !ok {
+		return nil
+	}
+	return c.removeLocked(ctx, el)
+}
+
+func (c *Cache) removeLocked(ctx context.Context, el *list.Element) error {
+	key := el.Value.(app.CachedMedia).Key
+	if err := c.storage.DeleteCachedMedia(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete cached media: %w", err)
+	}
+	c.lru.Remove(el)
+	delete(c.elementByKey, key)
+	return nil
+}
+
+func (c *Cache) isExpired(media app.CachedMedia) bool {
+	return c.maxAge > 0 && c.now().Sub(media.CachedAt) > c.maxAge
+}
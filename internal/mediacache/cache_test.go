@@ -0,0 +1,84 @@
+package mediacache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/storage/memory"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage := memory.New()
+	c, err := New(ctx, storage, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b"} {
+		if err := c.Put(ctx, app.CachedMedia{Key: key, FileIDs: []string{"file-" + key}}); err != nil {
+			t.Fatalf("Put(%q) error = %v", key, err)
+		}
+	}
+	// "a" becomes the most recently used, so "b" is evicted by "c".
+	if _, ok, _ := c.Get(ctx, "a"); !ok {
+		t.Fatalf("Get(a) ok = false, want true")
+	}
+	if err := c.Put(ctx, app.CachedMedia{Key: "c", FileIDs: []string{"file-c"}}); err != nil {
+		t.Fatalf("Put(c) error = %v", err)
+	}
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Errorf("Get(b) ok = true, want evicted entry")
+	}
+	stored, _ := storage.CachedMedia(ctx)
+	if len(stored) != 2 {
+		t.Errorf("storage has %d entries, want 2", len(stored))
+	}
+
+	if err := c.Invalidate(ctx, "c"); err != nil {
+		t.Fatalf("Invalidate(c) error = %v", err)
+	}
+	if _, ok, _ := c.Get(ctx, "c"); ok {
+		t.Errorf("Get(c) ok = true, want invalidated entry")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Errorf("Get(a) ok = true, want expired entry")
+	}
+	if stored, _ := storage.CachedMedia(ctx); len(stored) != 0 {
+		t.Errorf("storage has %d entries, want 0", len(stored))
+	}
+}
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	now := time.Now()
+	for i, media := range []app.CachedMedia{
+		{Key: "old", CachedAt: now.Add(-3 * time.Hour)},
+		{Key: "newest", CachedAt: now.Add(-time.Minute)},
+		{Key: "newer", CachedAt: now.Add(-2 * time.Minute)},
+		{Key: "evicted", CachedAt: now.Add(-3 * time.Minute)},
+	} {
+		if err := storage.SaveCachedMedia(ctx, media); err != nil {
+			t.Fatalf("SaveCachedMedia(%d) error = %v", i, err)
+		}
+	}
+	c, err := New(ctx, storage, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for key, want := range map[string]bool{"old": false, "newest": true, "newer": true, "evicted": false} {
+		if _, ok, _ := c.Get(ctx, key); ok != want {
+			t.Errorf("Get(%q) ok = %v, want %v", key, ok, want)
+		}
+	}
+	if stored, _ := storage.CachedMedia(ctx); len(stored) != 2 {
+		t.Errorf("storage has %d entries, want 2", len(stored))
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tgytbot"
+
+// registry contains all metrics of bot. Metrics are collected even if the endpoint is disabled, it's cheap.
+var registry = prometheus.NewRegistry()
+
+var (
+	UpdatesReceived = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_received_total",
+		Help:      "Number of updates received from Telegram by type: message, callback_query or other.",
+	}, []string{"type"}))
+	MessagesHandled = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_handled_total",
+		Help:      "Number of messages and callback queries handled by dialog with result: ok or error.",
+	}, []string{"dialog", "result"}))
+	HandlerDuration = register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Time of handling the update including waiting for the previous update of the same user.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16},
+	}))
+
+	DownloadsStarted = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_started_total",
+		Help:      "Number of started downloads by kind of media: audio or video.",
+	}, []string{"kind"}))
+	DownloadsFinished = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_finished_total",
+		Help:      "Number of finished downloads by kind of media and result: succeeded, failed or cancelled.",
+	}, []string{"kind", "result"}))
+	DownloadedBytes = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Bytes of source streams downloaded from YouTube.",
+	}))
+	UploadedBytes = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Bytes of media uploaded to Telegram by kind of media.",
+	}, []string{"kind"}))
+	FFmpegDuration = register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ffmpeg_duration_seconds",
+		Help:      "Running time of ffmpeg by operation: transcode, mux, cut or silencedetect.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+	}, []string{"operation"}))
+	ActiveJobs = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_jobs",
+		Help:      "Number of users whose downloads are running now.",
+	}))
+
+	TelegramAPIErrors = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_errors_total",
+		Help:      "Number of failed requests to Telegram Bot API by method.",
+	}, []string{"method"}))
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterQueueDepth registers the gauge of the number of downloads waiting in queues.
+// depth is called on every scrape.
+func RegisterQueueDepth(depth func() float64) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of downloads waiting in queues of all users.",
+	}, depth))
+}
+
+// Handler serves metrics in Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ByteCounter adds the size of written data to the counter.
+type ByteCounter struct {
+	prometheus.Counter
+}
+
+func (c ByteCounter) Write(p []byte) (int, error) {
+	c.Add(float64(len(p)))
+	return len(p), nil
+}
+
+func register[T prometheus.Collector](c T) T {
+	registry.MustRegister(c)
+	return c
+}
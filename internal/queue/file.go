@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+// FileQueue is app.DownloadQueue persisted to JSON file. The whole file is rewritten on every change,
+// it's fine for queues of a few dozens downloads.
+type FileQueue struct {
+	path string
+
+	mu          sync.Mutex
+	itemsByUser map[int64][]app.QueuedDownload
+}
+
+// NewFileQueue loads the queue from the file. The file is created on the first change if it doesn't exist.
+func NewFileQueue(path string) (*FileQueue, error) {
+	q := &FileQueue{
+		path:        path,
+		itemsByUser: make(map[int64][]app.QueuedDownload),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return q, nil
+		}
+		return nil, fmt.Errorf("failed to read queue file: %w", err)
+	}
+	if len(data) == 0 {
+		return q, nil
+	}
+	if err := json.Unmarshal(data, &q.itemsByUser); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal queue file %q: %w", path, err)
+	}
+	return q, nil
+}
+
+func (q *FileQueue) Push(ctx context.Context, item app.QueuedDownload) (position int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, err = fillNew(item)
+	if err != nil {
+		return 0, err
+	}
+	items := append(q.itemsByUser[item.UserID], item)
+	if err := q.save(item.UserID, items); err != nil {
+		return 0, err
+	}
+	return len(items), nil
+}
+
+func (q *FileQueue) PushFront(ctx context.Context, item app.QueuedDownload) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, err := fillNew(item)
+	if err != nil {
+		return err
+	}
+	items := make([]app.QueuedDownload, 0, len(q.itemsByUser[item.UserID])+1)
+	items = append(items, item)
+	items = append(items, q.itemsByUser[item.UserID]...)
+	return q.save(item.UserID, items)
+}
+
+func (q *FileQueue) Pop(ctx context.Context, userID int64) (item app.QueuedDownload, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.itemsByUser[userID]
+	if len(items) == 0 {
+		return app.QueuedDownload{}, false, nil
+	}
+	if err := q.save(userID, items[1:]); err != nil {
+		return app.QueuedDownload{}, false, err
+	}
+	return items[0], true, nil
+}
+
+func (q *FileQueue) List(ctx context.Context, userID int64) ([]app.QueuedDownload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.itemsByUser[userID]
+	return append([]app.QueuedDownload(nil), items...), nil
+}
+
+func (q *FileQueue) Remove(ctx context.Context, userID int64, id string) (ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.itemsByUser[userID]
+	for i, item := range items {
+		if item.ID != id {
+			continue
+		}
+		rest := make([]app.QueuedDownload, 0, len(items)-1)
+		rest = append(rest, items[:i]...)
+		rest = append(rest, items[i+1:]...)
+		if err := q.save(userID, rest); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (q *FileQueue) UserIDs(ctx context.Context) ([]int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]int64, 0, len(q.itemsByUser))
+	for id := range q.itemsByUser {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
+
+// save replaces the user's queue with items and writes all queues to the file.
+// The queue in memory isn't changed if writing is failed.
+func (q *FileQueue) save(userID int64, items []app.QueuedDownload) error {
+	itemsByUser := make(map[int64][]app.QueuedDownload, len(q.itemsByUser)+1)
+	for id, userItems := range q.itemsByUser {
+		itemsByUser[id] = userItems
+	}
+	if len(items) == 0 {
+		delete(itemsByUser, userID)
+	} else {
+		itemsByUser[userID] = items
+	}
+	data, err := json.MarshalIndent(itemsByUser, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal queue: %w", err)
+	}
+	if dir := filepath.Dir(q.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for queue file: %w", err)
+		}
+	}
+	// The file is replaced atomically, so it's never left half-written.
+	tmpPath := q.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write queue file: %w", err)
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		return fmt.Errorf("failed to replace queue file: %w", err)
+	}
+	q.itemsByUser = itemsByUser
+	return nil
+}
+
+// fillNew sets id and time of adding to the item which is put to the queue for the first time.
+func fillNew(item app.QueuedDownload) (app.QueuedDownload, error) {
+	if item.ID == "" {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return item, fmt.Errorf("failed to generate id: %w", err)
+		}
+		item.ID = id.String()
+	}
+	if item.AddedAt.IsZero() {
+		item.AddedAt = time.Now()
+	}
+	return item, nil
+}
@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+func TestFileQueue(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "queue", "queue.json")
+	q, err := NewFileQueue(path)
+	if err != nil {
+		t.Fatalf("NewFileQueue() error = %v", err)
+	}
+	for i, title := range []string{"first", "second", "third"} {
+		pos, err := q.Push(ctx, app.QueuedDownload{
+			UserID:  1,
+			Title:   title,
+			Request: &app.DownloadRequest{Link: "https://youtu.be/" + title},
+		})
+		if err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+		if pos != i+1 {
+			t.Errorf("Push() position = %v, want %v", pos, i+1)
+		}
+	}
+	if _, err := q.Push(ctx, app.QueuedDownload{UserID: 2, Title: "other user"}); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	items, _ := q.List(ctx, 1)
+	if ok, err := q.Remove(ctx, 1, items[1].ID); !ok || err != nil {
+		t.Fatalf("Remove() = %v, %v, want true, nil", ok, err)
+	}
+	if ok, _ := q.Remove(ctx, 1, "unknown"); ok {
+		t.Errorf("Remove() of unknown id = true, want false")
+	}
+
+	if err := q.PushFront(ctx, app.QueuedDownload{UserID: 1, ID: "interrupted", Title: "zero"}); err != nil {
+		t.Fatalf("PushFront() error = %v", err)
+	}
+
+	// The queue must be restored from the file after restart.
+	q, err = NewFileQueue(path)
+	if err != nil {
+		t.Fatalf("NewFileQueue() on existing file error = %v", err)
+	}
+	if ids, _ := q.UserIDs(ctx); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("UserIDs() = %v, want [1 2]", ids)
+	}
+	if item, _, _ := q.Pop(ctx, 1); item.ID != "interrupted" {
+		t.Errorf("Pop() = %+v, want item pushed to front", item)
+	}
+	for _, want := range []string{"first", "third"} {
+		item, ok, err := q.Pop(ctx, 1)
+		if err != nil || !ok {
+			t.Fatalf("Pop() = %v, %v, want true, nil", ok, err)
+		}
+		if item.Title != want || item.Request == nil || item.Request.Link != "https://youtu.be/"+want {
+			t.Errorf("Pop() = %+v, want item %q", item, want)
+		}
+	}
+	if _, ok, _ := q.Pop(ctx, 1); ok {
+		t.Errorf("Pop() of empty queue returned item")
+	}
+	if ids, _ := q.UserIDs(ctx); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("UserIDs() = %v, want [2]", ids)
+	}
+}
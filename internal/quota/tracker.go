@@ -0,0 +1,168 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+const (
+	oneMB = 1 << 20
+
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// Tracker is app.Quota. Usage is kept in memory, storage is used to restore it after restart.
+// Days and months are counted in the local time of server.
+type Tracker struct {
+	storage    app.QuotaStorage
+	defaults   app.QuotaLimits
+	rateLimit  int
+	rateWindow time.Duration
+	now        func() time.Time
+
+	mu            sync.Mutex
+	quotaByUserID map[int64]app.UserQuota
+	// requestsByUserID contains times of requests in the current rate limit window.
+	requestsByUserID map[int64][]time.Time
+}
+
+// New loads usage from storage. User can send no more than rateLimit requests within rateWindow,
+// zero rateLimit disables the limit.
+func New(ctx context.Context, storage app.QuotaStorage, defaults app.QuotaLimits, rateLimit int, rateWindow time.Duration) (*Tracker, error) {
+	t := &Tracker{
+		storage:          storage,
+		defaults:         defaults,
+		rateLimit:        rateLimit,
+		rateWindow:       rateWindow,
+		now:              time.Now,
+		quotaByUserID:    make(map[int64]app.UserQuota),
+		requestsByUserID: make(map[int64][]time.Time),
+	}
+	quotas, err := storage.UserQuotas(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load quotas: %w", err)
+	}
+	for _, q := range quotas {
+		t.quotaByUserID[q.UserID] = q
+	}
+	return t, nil
+}
+
+func (t *Tracker) CheckRate(userID int64) error {
+	if t.rateLimit <= 0 || t.rateWindow <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	requests := t.requestsByUserID[userID]
+	for len(requests) > 0 && !requests[0].After(now.Add(-t.rateWindow)) {
+		requests = requests[1:]
+	}
+	if len(requests) >= t.rateLimit {
+		t.requestsByUserID[userID] = requests
+		wait := requests[0].Add(t.rateWindow).Sub(now)
+		return app.NewUserError(fmt.Sprintf("Слишком много запросов. Повторите попытку через %d сек.", int(math.Ceil(wait.Seconds()))))
+	}
+	t.requestsByUserID[userID] = append(requests, now)
+	return nil
+}
+
+func (t *Tracker) Check(userID int64) error {
+	status := t.Status(userID)
+	if text := exceededLimit(status); text != "" {
+		return app.NewUserError(text + "\n\nОстаток лимитов: /quota").WithCause(app.ErrQuotaExceeded)
+	}
+	return nil
+}
+
+func (t *Tracker) Record(ctx context.Context, userID int64, usage app.QuotaUsage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := t.currentLocked(userID)
+	q.Daily = addUsage(q.Daily, usage)
+	q.Monthly = addUsage(q.Monthly, usage)
+	return t.saveLocked(ctx, q)
+}
+
+func (t *Tracker) Status(userID int64) app.QuotaStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := t.currentLocked(userID)
+	status := app.QuotaStatus{
+		Limits:  t.defaults,
+		Daily:   q.Daily,
+		Monthly: q.Monthly,
+	}
+	if q.Limits != nil {
+		status.Limits = *q.Limits
+		status.Overridden = true
+	}
+	return status
+}
+
+func (t *Tracker) SetLimits(ctx context.Context, userID int64, limits *app.QuotaLimits) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := t.currentLocked(userID)
+	q.Limits = limits
+	return t.saveLocked(ctx, q)
+}
+
+// currentLocked returns the quota of user with usage reset if the day or month is over.
+func (t *Tracker) currentLocked(userID int64) app.UserQuota {
+	q := t.quotaByUserID[userID]
+	q.UserID = userID
+	now := t.now()
+	if day := now.Format(dayLayout); q.Day != day {
+		q.Day = day
+		q.Daily = app.QuotaUsage{}
+	}
+	if month := now.Format(monthLayout); q.Month != month {
+		q.Month = month
+		q.Monthly = app.QuotaUsage{}
+	}
+	return q
+}
+
+func (t *Tracker) saveLocked(ctx context.Context, q app.UserQuota) error {
+	if err := t.storage.SaveUserQuota(ctx, q); err != nil {
+		return fmt.Errorf("failed to save quota: %w", err)
+	}
+	t.quotaByUserID[q.UserID] = q
+	return nil
+}
+
+// exceededLimit returns the description of the first spent limit or empty string if there isn't such one.
+func exceededLimit(status app.QuotaStatus) string {
+	l, daily, monthly := status.Limits, status.Daily, status.Monthly
+	switch {
+	case l.DailyDownloads > 0 && daily.Downloads >= l.DailyDownloads:
+		return fmt.Sprintf("Вы исчерпали дневной лимит загрузок: <b>%d</b>. Попробуйте завтра.", l.DailyDownloads)
+	case l.DailyMB > 0 && daily.Bytes >= l.DailyMB*oneMB:
+		return fmt.Sprintf("Вы исчерпали дневной лимит объема загрузок: <b>%d MB</b>. Попробуйте завтра.", l.DailyMB)
+	case l.DailyAudioMinutes > 0 && daily.AudioDuration >= time.Duration(l.DailyAudioMinutes)*time.Minute:
+		return fmt.Sprintf("Вы исчерпали дневной лимит длительности аудио: <b>%d мин.</b> Попробуйте завтра.", l.DailyAudioMinutes)
+	case l.MonthlyDownloads > 0 && monthly.Downloads >= l.MonthlyDownloads:
+		return fmt.Sprintf("Вы исчерпали месячный лимит загрузок: <b>%d</b>.", l.MonthlyDownloads)
+	case l.MonthlyMB > 0 && monthly.Bytes >= l.MonthlyMB*oneMB:
+		return fmt.Sprintf("Вы исчерпали месячный лимит объема загрузок: <b>%d MB</b>.", l.MonthlyMB)
+	case l.MonthlyAudioMinutes > 0 && monthly.AudioDuration >= time.Duration(l.MonthlyAudioMinutes)*time.Minute:
+		return fmt.Sprintf("Вы исчерпали месячный лимит длительности аудио: <b>%d мин.</b>", l.MonthlyAudioMinutes)
+	}
+	return ""
+}
+
+func addUsage(a, b app.QuotaUsage) app.QuotaUsage {
+	return app.QuotaUsage{
+		Downloads:     a.Downloads + b.Downloads,
+		Bytes:         a.Bytes + b.Bytes,
+		AudioDuration: a.AudioDuration + b.AudioDuration,
+	}
+}
@@ -0,0 +1,140 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/storage/memory"
+)
+
+const userID = 1
+
+func TestTracker_Check(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		limits  app.QuotaLimits
+		usage   app.QuotaUsage
+		wantErr bool
+	}{
+		{
+			name:  "should_allow_without_limits",
+			usage: app.QuotaUsage{Downloads: 100, Bytes: 100 * oneMB, AudioDuration: time.Hour},
+		},
+		{
+			name:   "should_allow_under_limits",
+			limits: app.QuotaLimits{DailyDownloads: 3, DailyMB: 10, DailyAudioMinutes: 60},
+			usage:  app.QuotaUsage{Downloads: 2, Bytes: 9 * oneMB, AudioDuration: 59 * time.Minute},
+		},
+		{
+			name:    "should_deny_when_downloads_are_spent",
+			limits:  app.QuotaLimits{DailyDownloads: 3},
+			usage:   app.QuotaUsage{Downloads: 3},
+			wantErr: true,
+		},
+		{
+			name:    "should_deny_when_megabytes_are_spent",
+			limits:  app.QuotaLimits{MonthlyMB: 10},
+			usage:   app.QuotaUsage{Downloads: 1, Bytes: 10 * oneMB},
+			wantErr: true,
+		},
+		{
+			name:    "should_deny_when_audio_minutes_are_spent",
+			limits:  app.QuotaLimits{MonthlyAudioMinutes: 60},
+			usage:   app.QuotaUsage{Downloads: 1, AudioDuration: time.Hour},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := New(ctx, memory.New(), tt.limits, 0, 0)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := tr.Record(ctx, userID, tt.usage); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+			err = tr.Check(userID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, app.ErrQuotaExceeded) {
+				t.Errorf("Check() error = %v, want caused by ErrQuotaExceeded", err)
+			}
+		})
+	}
+}
+
+func TestTracker_Record(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	storage := memory.New()
+	tr, err := New(ctx, storage, app.QuotaLimits{DailyDownloads: 1, MonthlyDownloads: 2}, 0, 0)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tr.now = func() time.Time { return now }
+
+	_ = tr.Record(ctx, userID, app.QuotaUsage{Downloads: 1})
+	if err := tr.Check(userID); err == nil {
+		t.Errorf("Check() error = nil, want spent daily quota")
+	}
+	// Admin lifts the limits of user.
+	if err := tr.SetLimits(ctx, userID, &app.QuotaLimits{}); err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+	if err := tr.Check(userID); err != nil {
+		t.Errorf("Check() with overridden limits error = %v", err)
+	}
+	if err := tr.SetLimits(ctx, userID, nil); err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+
+	// Usage is restored after restart, and daily usage is reset on the next day.
+	tr, err = New(ctx, storage, app.QuotaLimits{DailyDownloads: 1, MonthlyDownloads: 2}, 0, 0)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tr.now = func() time.Time { return now }
+	if err := tr.Check(userID); err == nil {
+		t.Errorf("Check() after restart error = nil, want spent daily quota")
+	}
+	now = now.Add(12 * time.Hour)
+	if err := tr.Check(userID); err != nil {
+		t.Errorf("Check() on the next day error = %v", err)
+	}
+	_ = tr.Record(ctx, userID, app.QuotaUsage{Downloads: 1})
+	status := tr.Status(userID)
+	if status.Overridden || status.Daily.Downloads != 1 || status.Monthly.Downloads != 1 {
+		t.Errorf("Status() = %+v, want default limits with usage of new day and month", status)
+	}
+}
+
+func TestTracker_CheckRate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr, err := New(context.Background(), memory.New(), app.QuotaLimits{}, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tr.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if err := tr.CheckRate(userID); err != nil {
+			t.Fatalf("CheckRate() #%d error = %v", i+1, err)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	if err := tr.CheckRate(userID); err == nil {
+		t.Errorf("CheckRate() error = nil, want rate limit error")
+	}
+	if err := tr.CheckRate(userID + 1); err != nil {
+		t.Errorf("CheckRate() of another user error = %v", err)
+	}
+	now = now.Add(45 * time.Second)
+	if err := tr.CheckRate(userID); err != nil {
+		t.Errorf("CheckRate() after window error = %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+)
+
+// Scheduler limits the number of jobs running at once. Waiting jobs are granted in round-robin order
+// across users, so the user having many jobs doesn't block others.
+type Scheduler struct {
+	maxJobs int
+
+	mu      sync.Mutex
+	running int
+	// waiting keeps FIFO of waiters for every user.
+	waiting map[int64][]*waiter
+	// users is the round-robin order of users having waiting jobs.
+	users []int64
+}
+
+type waiter struct {
+	ready chan struct{}
+}
+
+func New(maxJobs int) *Scheduler {
+	if maxJobs < 1 {
+		maxJobs = 1
+	}
+	return &Scheduler{
+		maxJobs: maxJobs,
+		waiting: make(map[int64][]*waiter),
+	}
+}
+
+// Acquire blocks until the job of user may run or ctx is done. If the job has to wait,
+// onQueued is called with its position in the line. release must be called after the job is finished.
+func (s *Scheduler) Acquire(ctx context.Context, userID int64, onQueued func(position int)) (release func(), err error) {
+	s.mu.Lock()
+	if s.running < s.maxJobs && len(s.users) == 0 {
+		s.running++
+		s.mu.Unlock()
+		return s.releaseFunc(), nil
+	}
+	w := &waiter{ready: make(chan struct{})}
+	if len(s.waiting[userID]) == 0 {
+		s.users = append(s.users, userID)
+	}
+	s.waiting[userID] = append(s.waiting[userID], w)
+	position := s.positionLocked(userID)
+	s.mu.Unlock()
+
+	if onQueued != nil {
+		onQueued(position)
+	}
+	select {
+	case <-w.ready:
+		return s.releaseFunc(), nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	select {
+	case <-w.ready:
+		// The slot was granted at the same time as ctx was done.
+		s.mu.Unlock()
+		s.release()
+		return nil, ctx.Err()
+	default:
+	}
+	s.removeWaiterLocked(userID, w)
+	s.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+// Position returns the position of the user's first waiting job in the line starting from 1.
+// Zero means that user has no waiting jobs.
+func (s *Scheduler) Position(userID int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.positionLocked(userID)
+}
+
+func (s *Scheduler) positionLocked(userID int64) int {
+	for i, id := range s.users {
+		if id == userID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (s *Scheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(s.release)
+	}
+}
+
+func (s *Scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	for s.running < s.maxJobs && len(s.users) > 0 {
+		userID := s.users[0]
+		s.users = s.users[1:]
+		waiters := s.waiting[userID]
+		w := waiters[0]
+		if len(waiters) > 1 {
+			s.waiting[userID] = waiters[1:]
+			// The user goes to the end of line with the rest of their jobs.
+			s.users = append(s.users, userID)
+		} else {
+			delete(s.waiting, userID)
+		}
+		s.running++
+		close(w.ready)
+	}
+}
+
+func (s *Scheduler) removeWaiterLocked(userID int64, w *waiter) {
+	waiters := s.waiting[userID]
+	for i := range waiters {
+		if waiters[i] != w {
+			continue
+		}
+		waiters = append(waiters[:i:i], waiters[i+1:]...)
+		break
+	}
+	if len(waiters) > 0 {
+		s.waiting[userID] = waiters
+		return
+	}
+	delete(s.waiting, userID)
+	for i, id := range s.users {
+		if id == userID {
+			s.users = append(s.users[:i:i], s.users[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduler_roundRobin(t *testing.T) {
+	ctx := context.Background()
+	s := New(1)
+	release, err := s.Acquire(ctx, 100, nil)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		order   []int64
+		wg      sync.WaitGroup
+		queued  = make(chan int, 4)
+		enqueue = func(userID int64) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := s.Acquire(ctx, userID, func(position int) { queued <- position })
+				if err != nil {
+					t.Errorf("Acquire() error = %v", err)
+					return
+				}
+				mu.Lock()
+				order = append(order, userID)
+				mu.Unlock()
+				release()
+			}()
+			<-queued
+		}
+	)
+	// User 1 has three jobs, user 2 has one job which is enqueued after all jobs of user 1.
+	enqueue(1)
+	enqueue(1)
+	enqueue(1)
+	enqueue(2)
+	if pos := s.Position(2); pos != 2 {
+		t.Errorf("Position() = %v, want %v", pos, 2)
+	}
+	release()
+	wg.Wait()
+
+	want := []int64{1, 2, 1, 1}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("jobs order = %v, want %v", order, want)
+	}
+	if pos := s.Position(1); pos != 0 {
+		t.Errorf("Position() of user without jobs = %v, want 0", pos)
+	}
+}
+
+func TestScheduler_cancelWaiting(t *testing.T) {
+	s := New(1)
+	release, _ := s.Acquire(context.Background(), 1, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Acquire(ctx, 2, nil); err == nil {
+		t.Fatalf("Acquire() error = nil, want context error")
+	}
+	if pos := s.Position(2); pos != 0 {
+		t.Errorf("Position() of cancelled job = %v, want 0", pos)
+	}
+	release()
+	if _, err := s.Acquire(context.Background(), 3, nil); err != nil {
+		t.Errorf("Acquire() after release error = %v", err)
+	}
+}
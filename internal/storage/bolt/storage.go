@@ -0,0 +1,204 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	bbolt "go.etcd.io/bbolt"
+)
+
+var (
+	dialogsBucket = []byte("dialogs")
+	jobsBucket    = []byte("active_jobs")
+	mediaBucket   = []byte("media_cache")
+	accessBucket  = []byte("access_rules")
+	quotaBucket   = []byte("quotas")
+)
+
+// openTimeout limits waiting for the file lock held by another process.
+const openTimeout = 5 * time.Second
+
+// Storage is app.Storage persisted to BoltDB file. Values are JSON documents keyed by id of user
+// or by key of cached media.
+type Storage struct {
+	db *bbolt.DB
+}
+
+// Open opens the database file creating it with its directory if it doesn't exist.
+func Open(path string) (*Storage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory of storage: %w", err)
+	}
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage file %q: %w", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{dialogsBucket, jobsBucket, mediaBucket, accessBucket, quotaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("failed to create bucket %q: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Storage{db: db}, nil
+}
+
+func (s *Storage) SaveDialog(ctx context.Context, dlg app.StoredDialog) error {
+	return s.put(dialogsBucket, userKey(dlg.UserID), dlg)
+}
+
+func (s *Storage) Dialogs(ctx context.Context) ([]app.StoredDialog, error) {
+	var res []app.StoredDialog
+	err := s.forEach(dialogsBucket, func(data []byte) error {
+		var dlg app.StoredDialog
+		if err := json.Unmarshal(data, &dlg); err != nil {
+			return err
+		}
+		res = append(res, dlg)
+		return nil
+	})
+	return res, err
+}
+
+func (s *Storage) SaveActiveJob(ctx context.Context, job app.QueuedDownload) error {
+	return s.put(jobsBucket, userKey(job.UserID), job)
+}
+
+func (s *Storage) DeleteActiveJob(ctx context.Context, userID int64) error {
+	return s.delete(jobsBucket, userKey(userID))
+}
+
+func (s *Storage) ActiveJobs(ctx context.Context) ([]app.QueuedDownload, error) {
+	var res []app.QueuedDownload
+	err := s.forEach(jobsBucket, func(data []byte) error {
+		var job app.QueuedDownload
+		if err := json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+		res = append(res, job)
+		return nil
+	})
+	return res, err
+}
+
+func (s *Storage) SaveCachedMedia(ctx context.Context, media app.CachedMedia) error {
+	return s.put(mediaBucket, []byte(media.Key), media)
+}
+
+func (s *Storage) DeleteCachedMedia(ctx context.Context, key string) error {
+	return s.delete(mediaBucket, []byte(key))
+}
+
+func (s *Storage) CachedMedia(ctx context.Context) ([]app.CachedMedia, error) {
+	var res []app.CachedMedia
+	err := s.forEach(mediaBucket, func(data []byte) error {
+		var media app.CachedMedia
+		if err := json.Unmarshal(data, &media); err != nil {
+			return err
+		}
+		res = append(res, media)
+		return nil
+	})
+	return res, err
+}
+
+func (s *Storage) SaveAccessRule(ctx context.Context, rule app.AccessRule) error {
+	return s.put(accessBucket, userKey(rule.UserID), rule)
+}
+
+func (s *Storage) AccessRules(ctx context.Context) ([]app.AccessRule, error) {
+	var res []app.AccessRule
+	err := s.forEach(accessBucket, func(data []byte) error {
+		var rule app.AccessRule
+		if err := json.Unmarshal(data, &rule); err != nil {
+			return err
+		}
+		res = append(res, rule)
+		return nil
+	})
+	return res, err
+}
+
+func (s *Storage) SaveUserQuota(ctx context.Context, quota app.UserQuota) error {
+	return s.put(quotaBucket, userKey(quota.UserID), quota)
+}
+
+func (s *Storage) UserQuotas(ctx context.Context) ([]app.UserQuota, error) {
+	var res []app.UserQuota
+	err := s.forEach(quotaBucket, func(data []byte) error {
+		var quota app.UserQuota
+		if err := json.Unmarshal(data, &quota); err != nil {
+			return err
+		}
+		res = append(res, quota)
+		return nil
+	})
+	return res, err
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func (s *Storage) put(bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value of bucket %q: %w", bucket, err)
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put value to bucket %q: %w", bucket, err)
+	}
+	return nil
+}
+
+func (s *Storage) delete(bucket, key []byte) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete value from bucket %q: %w", bucket, err)
+	}
+	return nil
+}
+
+func (s *Storage) forEach(bucket []byte, fn func(data []byte) error) error {
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			if err := fn(v); err != nil {
+				return fmt.Errorf("failed to unmarshal value of key %x: %w", k, err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read bucket %q: %w", bucket, err)
+	}
+	return nil
+}
+
+// userKey encodes id of user in big endian, so keys are sorted by id.
+func userKey(userID int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(userID))
+	return key
+}
@@ -0,0 +1,94 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data", "tgytbot.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	dialogs := []app.StoredDialog{
+		{UserID: 2, UserName: "second", ID: app.DialogPlaylist, State: json.RawMessage(`{"link":"https://youtu.be/x"}`)},
+		{UserID: 1, UserName: "first", ID: app.DialogMain},
+	}
+	for _, dlg := range dialogs {
+		if err := s.SaveDialog(ctx, dlg); err != nil {
+			t.Fatalf("SaveDialog() error = %v", err)
+		}
+	}
+	// The dialog of user is replaced by the new one.
+	dialogs[1].ID = app.DialogSettings
+	if err := s.SaveDialog(ctx, dialogs[1]); err != nil {
+		t.Fatalf("SaveDialog() error = %v", err)
+	}
+	for _, job := range []app.QueuedDownload{
+		{ID: "a", UserID: 1, Title: "first"},
+		{ID: "b", UserID: 2, Title: "second"},
+	} {
+		if err := s.SaveActiveJob(ctx, job); err != nil {
+			t.Fatalf("SaveActiveJob() error = %v", err)
+		}
+	}
+	if err := s.DeleteActiveJob(ctx, 1); err != nil {
+		t.Fatalf("DeleteActiveJob() error = %v", err)
+	}
+	for _, rule := range []app.AccessRule{
+		{UserID: 3, Banned: true, ChangedBy: 1},
+		{UserID: 3, Banned: false, ChangedBy: 2},
+	} {
+		if err := s.SaveAccessRule(ctx, rule); err != nil {
+			t.Fatalf("SaveAccessRule() error = %v", err)
+		}
+	}
+	if err := s.Ping(ctx); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := s.Ping(ctx); err == nil {
+		t.Errorf("Ping() of closed storage error = nil, want error")
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() of existing file error = %v", err)
+	}
+	defer s.Close()
+	gotDialogs, err := s.Dialogs(ctx)
+	if err != nil {
+		t.Fatalf("Dialogs() error = %v", err)
+	}
+	if len(gotDialogs) != 2 {
+		t.Fatalf("Dialogs() returned %d dialogs, want 2", len(gotDialogs))
+	}
+	if got := gotDialogs[0]; got.UserID != 1 || got.ID != app.DialogSettings || got.UserName != "first" {
+		t.Errorf("Dialogs()[0] = %+v, want settings dialog of user 1", got)
+	}
+	if got := gotDialogs[1]; got.UserID != 2 || string(got.State) != string(dialogs[0].State) {
+		t.Errorf("Dialogs()[1] = %+v, want %+v", got, dialogs[0])
+	}
+	jobs, err := s.ActiveJobs(ctx)
+	if err != nil {
+		t.Fatalf("ActiveJobs() error = %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "b" {
+		t.Errorf("ActiveJobs() = %+v, want only job b", jobs)
+	}
+	rules, err := s.AccessRules(ctx)
+	if err != nil {
+		t.Fatalf("AccessRules() error = %v", err)
+	}
+	if len(rules) != 1 || rules[0].Banned || rules[0].ChangedBy != 2 {
+		t.Errorf("AccessRules() = %+v, want only the last rule of user 3", rules)
+	}
+}
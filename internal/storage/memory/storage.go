@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+)
+
+// Storage is app.Storage which keeps everything in memory, so the state is lost on restart.
+type Storage struct {
+	mu             sync.Mutex
+	dialogByUserID map[int64]app.StoredDialog
+	jobByUserID    map[int64]app.QueuedDownload
+	mediaByKey     map[string]app.CachedMedia
+	ruleByUserID   map[int64]app.AccessRule
+	quotaByUserID  map[int64]app.UserQuota
+}
+
+func New() *Storage {
+	return &Storage{
+		dialogByUserID: make(map[int64]app.StoredDialog),
+		jobByUserID:    make(map[int64]app.QueuedDownload),
+		mediaByKey:     make(map[string]app.CachedMedia),
+		ruleByUserID:   make(map[int64]app.AccessRule),
+		quotaByUserID:  make(map[int64]app.UserQuota),
+	}
+}
+
+func (s *Storage) SaveDialog(ctx context.Context, dlg app.StoredDialog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dialogByUserID[dlg.UserID] = dlg
+	return nil
+}
+
+func (s *Storage) Dialogs(ctx context.Context) ([]app.StoredDialog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]app.StoredDialog, 0, len(s.dialogByUserID))
+	for _, dlg := range s.dialogByUserID {
+		res = append(res, dlg)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+	return res, nil
+}
+
+func (s *Storage) SaveActiveJob(ctx context.Context, job app.QueuedDownload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobByUserID[job.UserID] = job
+	return nil
+}
+
+func (s *Storage) DeleteActiveJob(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobByUserID, userID)
+	return nil
+}
+
+func (s *Storage) ActiveJobs(ctx context.Context) ([]app.QueuedDownload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]app.QueuedDownload, 0, len(s.jobByUserID))
+	for _, job := range s.jobByUserID {
+		res = append(res, job)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+	return res, nil
+}
+
+func (s *Storage) SaveCachedMedia(ctx context.Context, media app.CachedMedia) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mediaByKey[media.Key] = media
+	return nil
+}
+
+func (s *Storage) DeleteCachedMedia(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mediaByKey, key)
+	return nil
+}
+
+func (s *Storage) CachedMedia(ctx context.Context) ([]app.CachedMedia, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]app.CachedMedia, 0, len(s.mediaByKey))
+	for _, media := range s.mediaByKey {
+		res = append(res, media)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res, nil
+}
+
+func (s *Storage) SaveAccessRule(ctx context.Context, rule app.AccessRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ruleByUserID[rule.UserID] = rule
+	return nil
+}
+
+func (s *Storage) AccessRules(ctx context.Context) ([]app.AccessRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]app.AccessRule, 0, len(s.ruleByUserID))
+	for _, rule := range s.ruleByUserID {
+		res = append(res, rule)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+	return res, nil
+}
+
+func (s *Storage) SaveUserQuota(ctx context.Context, quota app.UserQuota) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quotaByUserID[quota.UserID] = quota
+	return nil
+}
+
+func (s *Storage) UserQuotas(ctx context.Context) ([]app.UserQuota, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]app.UserQuota, 0, len(s.quotaByUserID))
+	for _, quota := range s.quotaByUserID {
+		res = append(res, quota)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+	return res, nil
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Storage) Close() error {
+	return nil
+}
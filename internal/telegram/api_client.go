@@ -0,0 +1,24 @@
+package telegram
+
+import (
+	"net/http"
+	"path"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/metrics"
+)
+
+// apiClient counts failed requests to Bot API by method. Telegram responds with non-200 status
+// if the request isn't successful.
+type apiClient struct {
+	client tgbotapi.HTTPClient
+}
+
+func (c apiClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		// The path looks like /bot<token>/sendMessage.
+		metrics.TelegramAPIErrors.WithLabelValues(path.Base(req.URL.Path)).Inc()
+	}
+	return resp, err
+}
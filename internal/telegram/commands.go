@@ -0,0 +1,187 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+const historyTimeLayout = "02.01.2006 15:04"
+
+// botCommand is the command available in any dialog.
+type botCommand struct {
+	app.Command
+	// leavesDialog is true when the command redirects user to another dialog. Such commands are rejected
+	// in the download dialog, because the running download can't be controlled after leaving it.
+	leavesDialog bool
+	// adminOnly commands are hidden from the menu of bot and rejected for other users.
+	adminOnly bool
+	handle    func(ctx context.Context, rup app.ReqUserProvider, dlg app.Dialog, args string) error
+}
+
+func (p *MsgProcessor) globalCommands() []botCommand {
+	return []botCommand{
+		{Command: app.Command{Name: "start", Description: "Начать работу с ботом"}, leavesDialog: true, handle: p.onStart},
+		{Command: app.Command{Name: "help", Description: "Список команд"}, handle: p.onHelp},
+		{Command: app.Command{Name: "cancel", Description: "Отменить текущее действие"}, leavesDialog: true, handle: p.onCancel},
+		{Command: app.Command{Name: "settings", Description: "Настройки"}, leavesDialog: true, handle: p.onSettings},
+		{Command: app.Command{Name: "history", Description: "История загрузок"}, handle: p.onHistory},
+		{Command: app.Command{Name: "quota", Description: "Остаток лимитов загрузок"}, handle: p.onQuota},
+		{Command: app.Command{Name: "setquota", Description: "Изменить лимиты пользователя: /setquota <id> downloads_day=10 ... или default"}, adminOnly: true, handle: p.onSetQuota},
+		{Command: app.Command{Name: "ban", Description: "Заблокировать пользователя по идентификатору"}, adminOnly: true, handle: p.onBan},
+		{Command: app.Command{Name: "allow", Description: "Разрешить пользователю доступ по идентификатору"}, adminOnly: true, handle: p.onAllow},
+	}
+}
+
+// registerCommands sets the list of commands shown by Telegram in the menu of bot.
+// It contains commands available in any dialog and commands declared by dialogs.
+func (p *MsgProcessor) registerCommands() error {
+	var cmds []tgbotapi.BotCommand
+	seen := make(map[string]struct{})
+	add := func(cmd app.Command) {
+		if _, ok := seen[cmd.Name]; ok {
+			return
+		}
+		seen[cmd.Name] = struct{}{}
+		cmds = append(cmds, tgbotapi.BotCommand{Command: cmd.Name, Description: cmd.Description})
+	}
+	for _, cmd := range p.globalCommands() {
+		if !cmd.adminOnly {
+			add(cmd.Command)
+		}
+	}
+	for _, cmd := range p.container.Commands() {
+		add(cmd)
+	}
+	if _, err := p.bot.Request(tgbotapi.NewSetMyCommands(cmds...)); err != nil {
+		return fmt.Errorf("failed to set bot commands: %w", err)
+	}
+	return nil
+}
+
+// handleCommand routes the command to the current dialog if it declares the command, or to the command available in any dialog.
+func (p *MsgProcessor) handleCommand(ctx context.Context, rup app.ReqUserProvider, dlg app.Dialog, name, args string) error {
+	log := logging.FromContextS(ctx)
+	if cmdDlg, ok := dlg.(app.CommandDialog); ok {
+		for _, cmd := range cmdDlg.Commands() {
+			if cmd.Name == name {
+				log.Infof("Command /%s is handled by dialog %T", name, dlg)
+				return cmdDlg.OnCommand(ctx, name, args)
+			}
+		}
+	}
+	for _, cmd := range p.globalCommands() {
+		if cmd.Name != name {
+			continue
+		}
+		if cmd.adminOnly && !p.access.IsAdmin(rup.User().ID) {
+			return app.NewUserError("Эта команда доступна только администраторам бота.")
+		}
+		if _, ok := dlg.(app.DownloadDialog); ok && cmd.leavesDialog {
+			return app.NewUserError("Сейчас идет загрузка. Дождитесь ее окончания или прервите ее командой /cancel.")
+		}
+		return cmd.handle(ctx, rup, dlg, args)
+	}
+	return app.NewUserError("Неизвестная команда. Список доступных команд: /help")
+}
+
+func (p *MsgProcessor) onStart(ctx context.Context, rup app.ReqUserProvider, _ app.Dialog, _ string) error {
+	if _, err := app.SendMessagef(ctx, rup, "Привет! Отправьте ссылку на YouTube-ролик или плейлист, чтобы получить аудиозапись или видео.\n\nСписок команд: /help"); err != nil {
+		return err
+	}
+	_, err := rup.RedirectToDialog(ctx, app.DialogMain)
+	return err
+}
+
+func (p *MsgProcessor) onHelp(ctx context.Context, rup app.ReqUserProvider, dlg app.Dialog, _ string) error {
+	text := &strings.Builder{}
+	text.WriteString("Отправьте ссылку на YouTube-ролик или плейлист, чтобы получить аудиозапись или видео.\n\n<b>Команды:</b>\n")
+	seen := make(map[string]struct{})
+	if cmdDlg, ok := dlg.(app.CommandDialog); ok {
+		for _, cmd := range cmdDlg.Commands() {
+			seen[cmd.Name] = struct{}{}
+			_, _ = fmt.Fprintf(text, "/%s — %s\n", cmd.Name, html.EscapeString(cmd.Description))
+		}
+	}
+	isAdmin := p.access.IsAdmin(rup.User().ID)
+	for _, cmd := range p.globalCommands() {
+		if _, ok := seen[cmd.Name]; ok || (cmd.adminOnly && !isAdmin) {
+			continue
+		}
+		_, _ = fmt.Fprintf(text, "/%s — %s\n", cmd.Name, html.EscapeString(cmd.Description))
+	}
+	_, err := app.SendMessagef(ctx, rup, "%s", text.String())
+	return err
+}
+
+func (p *MsgProcessor) onCancel(ctx context.Context, rup app.ReqUserProvider, _ app.Dialog, _ string) error {
+	if _, err := app.SendMessagef(ctx, rup, "Действие отменено. Отправьте ссылку на YouTube-ролик или плейлист."); err != nil {
+		return err
+	}
+	_, err := rup.RedirectToDialog(ctx, app.DialogMain)
+	return err
+}
+
+func (p *MsgProcessor) onSettings(ctx context.Context, rup app.ReqUserProvider, _ app.Dialog, _ string) error {
+	_, err := rup.RedirectToDialog(ctx, app.DialogSettings)
+	return err
+}
+
+func (p *MsgProcessor) onHistory(ctx context.Context, rup app.ReqUserProvider, _ app.Dialog, _ string) error {
+	entries := p.container.History().List(rup.User().ID)
+	if len(entries) == 0 {
+		_, err := app.SendMessagef(ctx, rup, "Вы еще ничего не скачали.")
+		return err
+	}
+	lines := make([]string, 0, len(entries))
+	for i, e := range entries {
+		icon := "🎵"
+		if e.Kind == app.MediaVideo {
+			icon = "🎬"
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s <a href=\"%s\">%s</a> — <i>%s</i>", i+1, icon, html.EscapeString(e.Link), html.EscapeString(e.Title), e.DownloadedAt.Format(historyTimeLayout)))
+	}
+	_, err := app.SendMessagef(ctx, rup, "<b>Ваши последние загрузки:</b>\n%s", strings.Join(lines, "\n"))
+	return err
+}
+
+func (p *MsgProcessor) onBan(ctx context.Context, rup app.ReqUserProvider, _ app.Dialog, args string) error {
+	userID, err := parseUserID("ban", args)
+	if err != nil {
+		return err
+	}
+	if err := p.access.Ban(ctx, rup.User().ID, userID); err != nil {
+		return err
+	}
+	logging.FromContextS(ctx).Infof("User %d is banned", userID)
+	_, err = app.SendMessagef(ctx, rup, "Пользователь <code>%d</code> заблокирован.", userID)
+	return err
+}
+
+func (p *MsgProcessor) onAllow(ctx context.Context, rup app.ReqUserProvider, _ app.Dialog, args string) error {
+	userID, err := parseUserID("allow", args)
+	if err != nil {
+		return err
+	}
+	if err := p.access.Allow(ctx, rup.User().ID, userID); err != nil {
+		return err
+	}
+	logging.FromContextS(ctx).Infof("User %d is allowed", userID)
+	_, err = app.SendMessagef(ctx, rup, "Пользователю <code>%d</code> разрешен доступ к боту.", userID)
+	return err
+}
+
+// parseUserID parses the argument of admin command which is the id of user.
+func parseUserID(cmdName, args string) (int64, error) {
+	userID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		return 0, app.NewUserError(fmt.Sprintf("Укажите числовой идентификатор пользователя, например <code>/%s 123456789</code>.", cmdName)).WithCause(err)
+	}
+	return userID, nil
+}
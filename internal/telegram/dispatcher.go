@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/vm-affekt/tgytbot/internal/app"
 	"github.com/vm-affekt/tgytbot/internal/logging"
+	"github.com/vm-affekt/tgytbot/internal/metrics"
 	"sync"
 	"time"
 )
@@ -15,85 +16,187 @@ import (
 func (p *MsgProcessor) startDispatcher() {
 	ctx := context.Background()
 	ctx, p.cancelDispatcher = context.WithCancel(ctx)
-	go p.startUpdListener(ctx)
+	p.dispatcherDone = make(chan struct{})
+	go func() {
+		defer close(p.dispatcherDone)
+		p.startUpdListener(ctx)
+	}()
 }
 
 func (p *MsgProcessor) startUpdListener(gCtx context.Context) {
 	log := logging.FromContextS(gCtx)
 	log.Info("Message receiver started... The bot is ready to process new messages!")
-	for upd := range p.updates {
-		var (
-			msg  *tgbotapi.Message
-			from *tgbotapi.User
-		)
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	p.beat()
+	defer p.loopBeat.Store(0)
+	for {
+		var upd tgbotapi.Update
+		select {
+		case <-heartbeat.C:
+			p.beat()
+			continue
+		case <-gCtx.Done():
+			p.drainUpdates()
+			log.Info("Message receiver is stopped")
+			return
+		case u, ok := <-p.updates:
+			if !ok {
+				log.Info("Updates channel is closed. Message receiver is stopped")
+				return
+			}
+			upd = u
+			p.beat()
+		}
+		p.dispatch(upd)
+	}
+}
 
-		switch {
-		case upd.Message != nil:
-			msg = upd.Message
-			from = msg.From
+// drainUpdates handles updates left in the buffer of channel. Their offset is already confirmed to Telegram
+// by the next getUpdates or they are answered by webhook, so they aren't sent again.
+// Updates received after that aren't confirmed and are sent by Telegram after restart.
+func (p *MsgProcessor) drainUpdates() {
+	for {
+		select {
+		case upd, ok := <-p.updates:
+			if !ok {
+				return
+			}
+			p.dispatch(upd)
 		default:
-			continue
+			return
 		}
+	}
+}
 
-		p.muLocker.Lock()
-		mu, ok := p.lockByUserID[from.ID] // we can handle only one message from certain user at once
-		if !ok {
-			mu = new(sync.Mutex)
-			p.lockByUserID[from.ID] = mu
+// dispatch runs the handler of update in a separate goroutine.
+func (p *MsgProcessor) dispatch(upd tgbotapi.Update) {
+	var (
+		from    *tgbotapi.User
+		chatID  int64
+		logText string
+		handle  func(ctx context.Context, rup app.ReqUserProvider, dlg app.Dialog) error
+		// deny is called instead of handle when user has no access to the bot.
+		deny func(ctx context.Context)
+	)
+
+	switch {
+	case upd.Message != nil:
+		metrics.UpdatesReceived.WithLabelValues("message").Inc()
+		msg := upd.Message
+		from = msg.From
+		chatID = msg.Chat.ID
+		logText = fmt.Sprintf("Received message %q", msg.Text)
+		handle = func(ctx context.Context, rup app.ReqUserProvider, dlg app.Dialog) error {
+			if msg.IsCommand() {
+				return p.handleCommand(ctx, rup, dlg, msg.Command(), msg.CommandArguments())
+			}
+			return dlg.OnMessage(ctx, msg.Text, msg.MessageID)
 		}
-		p.muLocker.Unlock()
+	case upd.CallbackQuery != nil && upd.CallbackQuery.Message != nil:
+		metrics.UpdatesReceived.WithLabelValues("callback_query").Inc()
+		query := upd.CallbackQuery
+		from = query.From
+		chatID = query.Message.Chat.ID
+		logText = fmt.Sprintf("Received callback query with data %q", query.Data)
+		handle = func(ctx context.Context, _ app.ReqUserProvider, dlg app.Dialog) error {
+			defer p.answerCallbackQuery(ctx, query.ID)
+			return dlg.OnCallback(ctx, query.Data, query.Message.MessageID)
+		}
+		deny = func(ctx context.Context) {
+			p.answerCallbackQuery(ctx, query.ID)
+		}
+	default:
+		metrics.UpdatesReceived.WithLabelValues("other").Inc()
+		return
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second) // Parent of this context is Background, not a gCtx. Because cancellation of gCtx should'nt interrupt message handling.
-		go func() {
-			start := time.Now()
-			mu.Lock()
-			defer mu.Unlock()
-			rqID := genRequestID()
-			userID := from.ID
-			ctx, log = logging.NewContextSL(ctx,
-				"request_id", rqID,
-				"user_tg_id", userID,
-				"user_name", from.UserName,
+	mu := p.userLock(from.ID) // we can handle only one message from certain user at once
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second) // Parent of this context is Background, not a gCtx. Because cancellation of gCtx should'nt interrupt message handling.
+	p.handlersWG.Add(1)
+	go func() {
+		defer p.handlersWG.Done()
+		defer p.trackHandler()()
+		start := time.Now()
+		mu.Lock()
+		defer mu.Unlock()
+		rqID := genRequestID()
+		userID := from.ID
+		ctx, log := logging.NewContextSL(ctx,
+			"request_id", rqID,
+			"user_tg_id", userID,
+			"user_name", from.UserName,
+		)
+		log.Info(logText)
+		rup := NewReqUserProvider(p.bot, from, chatID, p.userDialogState, p.container)
+		defer func() {
+			if r := recover(); r != nil {
+				log.With("recovered_obj", r).Error("!!! A PANIC occurred while handling query !!! See recovered object in recovered_obj!")
+			}
+			_, _ = app.SendMessagef(ctx, rup, "При обработки вашего сообщения произошла ошибка. Идентификатор запроса: %v", rqID)
+			totalElapsedTime := time.Since(start)
+			metrics.HandlerDuration.Observe(totalElapsedTime.Seconds())
+			log.Infow("Query is proceeded.",
+				"total_elapsed_time", totalElapsedTime,
 			)
-			text := msg.Text
-			log.Infof("Received message %q", text)
-			rup := NewReqUserProvider(p.bot, from, p.userDialogState, p.container)
-			defer func() {
-				if r := recover(); r != nil {
-					log.With("recovered_obj", r).Error("!!! A PANIC occurred while handling query !!! See recovered object in recovered_obj!")
-				}
-				_, _ = app.SendMessagef(ctx, rup, "При обработки вашего сообщения произошла ошибка. Идентификатор запроса: %v", rqID)
-				totalElapsedTime := time.Since(start)
-				log.Infow("Query is proceeded.",
-					"total_elapsed_time", totalElapsedTime,
-				)
-			}()
-			defer cancel()
+		}()
+		defer cancel()
 
-			currentDialog := p.userDialogState.FindDialogByUser(userID)
-			if currentDialog == nil {
-				var err error
-				currentDialog, err = p.initUser(ctx, rup)
-				if err != nil {
-					log.Errorf("Failed to init user: %v", err)
-					_, _ = app.SendMessagef(ctx, rup, "При регистрации вашего пользователя в системе произошла ошибка. Идентификатор запроса: %v", rqID)
-					return
-				}
+		if err := p.access.CheckAccess(userID, chatID); err != nil {
+			log.Warnf("Access denied: %v", err)
+			if deny != nil {
+				deny(ctx)
 			}
-			if err := currentDialog.OnMessage(ctx, text, msg.MessageID); err != nil {
-				log.Errorf("Failed to process message: %v", err)
-				var usrErr *app.UserError
-				if errors.As(err, &usrErr) {
-					_, _ = app.SendMessagef(ctx, rup, usrErr.UserMessage)
-				} else {
-					_, _ = app.SendMessagef(ctx, rup, "При обработке сообщения возникла ошибка. Попробуйте попытку позже. Идентификатор запроса: %v", rqID)
-				}
+			var usrErr *app.UserError
+			if errors.As(err, &usrErr) {
+				_, _ = app.SendMessagef(ctx, rup, usrErr.UserMessage)
 			}
+			return
+		}
+		currentDialog := p.userDialogState.FindDialogByUser(userID)
+		if currentDialog == nil {
+			var err error
+			currentDialog, err = p.initUser(ctx, rup)
+			if err != nil {
+				log.Errorf("Failed to init user: %v", err)
+				_, _ = app.SendMessagef(ctx, rup, "При регистрации вашего пользователя в системе произошла ошибка. Идентификатор запроса: %v", rqID)
+				return
+			}
+		}
+		dialogID, _ := p.userDialogState.FindDialogIDByUser(userID)
+		result := "ok"
+		if err := handle(ctx, rup, currentDialog); err != nil {
+			result = "error"
+			log.Errorf("Failed to process message: %v", err)
+			var usrErr *app.UserError
+			if errors.As(err, &usrErr) {
+				_, _ = app.SendMessagef(ctx, rup, usrErr.UserMessage)
+			} else {
+				_, _ = app.SendMessagef(ctx, rup, "При обработке сообщения возникла ошибка. Попробуйте попытку позже. Идентификатор запроса: %v", rqID)
+			}
+		}
+		metrics.MessagesHandled.WithLabelValues(dialogID.String(), result).Inc()
+		p.saveDialog(ctx, from)
+	}()
+}
 
-		}()
-
+// answerCallbackQuery hides the loading indicator of pressed inline button.
+func (p *MsgProcessor) answerCallbackQuery(ctx context.Context, queryID string) {
+	if _, err := p.bot.Request(tgbotapi.NewCallback(queryID, "")); err != nil {
+		logging.FromContextS(ctx).Warnf("Failed to answer callback query: %v", err)
 	}
+}
 
+func (p *MsgProcessor) userLock(userID int64) *sync.Mutex {
+	p.muLocker.Lock()
+	defer p.muLocker.Unlock()
+	mu, ok := p.lockByUserID[userID]
+	if !ok {
+		mu = new(sync.Mutex)
+		p.lockByUserID[userID] = mu
+	}
+	return mu
 }
 
 func (p *MsgProcessor) initUser(ctx context.Context, rup app.ReqUserProvider) (mainDlg app.Dialog, err error) {
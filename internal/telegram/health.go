@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// heartbeatInterval is how often the idle update loop reports that it's alive.
+	heartbeatInterval = 5 * time.Second
+	// loopStuckTimeout is the time after which the update loop without heartbeats is considered stuck.
+	loopStuckTimeout = 30 * time.Second
+	// handlerStuckTimeout is the time after which the handler of message is considered stuck.
+	// Handlers work under the timeout of 8 seconds, so they can exceed it only waiting for the lock of user.
+	handlerStuckTimeout = 2 * time.Minute
+)
+
+// Connected checks that the bot is connected to Telegram.
+func (p *MsgProcessor) Connected(_ context.Context) error {
+	if !p.connected.Load() {
+		return errors.New("bot isn't connected to Telegram")
+	}
+	return nil
+}
+
+// UpdateLoopAlive checks that the loop receiving updates is running and isn't stuck.
+func (p *MsgProcessor) UpdateLoopAlive(_ context.Context) error {
+	beat := p.loopBeat.Load()
+	if beat == 0 {
+		return errors.New("update loop isn't running")
+	}
+	if since := time.Since(time.Unix(0, beat)); since > loopStuckTimeout {
+		return fmt.Errorf("update loop hasn't reported for %v", since.Round(time.Second))
+	}
+	return nil
+}
+
+// Alive checks that the dispatcher isn't stuck: the update loop reports in time and no handler runs too long.
+// The dispatcher which isn't started yet or is already stopped is alive, so the bot isn't restarted while it connects.
+func (p *MsgProcessor) Alive(_ context.Context) error {
+	if beat := p.loopBeat.Load(); beat != 0 {
+		if since := time.Since(time.Unix(0, beat)); since > loopStuckTimeout {
+			return fmt.Errorf("update loop hasn't reported for %v", since.Round(time.Second))
+		}
+	}
+	if oldest := p.oldestHandlerStart(); !oldest.IsZero() {
+		if since := time.Since(oldest); since > handlerStuckTimeout {
+			return fmt.Errorf("message handler is running for %v", since.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+func (p *MsgProcessor) beat() {
+	p.loopBeat.Store(time.Now().UnixNano())
+}
+
+// trackHandler registers the running handler. The returned func must be called when the handler is finished.
+func (p *MsgProcessor) trackHandler() (done func()) {
+	p.muHandlers.Lock()
+	defer p.muHandlers.Unlock()
+	p.lastHandlerID++
+	id := p.lastHandlerID
+	p.handlerStarts[id] = time.Now()
+	return func() {
+		p.muHandlers.Lock()
+		defer p.muHandlers.Unlock()
+		delete(p.handlerStarts, id)
+	}
+}
+
+func (p *MsgProcessor) oldestHandlerStart() time.Time {
+	p.muHandlers.Lock()
+	defer p.muHandlers.Unlock()
+	var oldest time.Time
+	for _, start := range p.handlerStarts {
+		if oldest.IsZero() || start.Before(oldest) {
+			oldest = start
+		}
+	}
+	return oldest
+}
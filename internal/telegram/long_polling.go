@@ -9,11 +9,18 @@ func (p *MsgProcessor) StartLongPolling(updTimeout int32) error {
 	if err := p.connect(); err != nil {
 		return fmt.Errorf("failed to connect Telegram server: %w", err)
 	}
+	// Telegram doesn't return updates by getUpdates while the webhook is set.
+	if _, err := p.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
 	updCfg := tgbotapi.NewUpdate(0)
 	updCfg.Timeout = int(updTimeout)
 
 	p.updates = p.bot.GetUpdatesChan(updCfg)
+	p.restoreDialogs()
+	p.recoverActiveJobs()
 	p.startDispatcher()
+	p.resumeQueues()
 
 	return nil
 }
@@ -6,7 +6,10 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/vm-affekt/tgytbot/internal/app"
 	"github.com/vm-affekt/tgytbot/internal/dialogs"
+	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type MsgProcessor struct {
@@ -15,22 +18,39 @@ type MsgProcessor struct {
 
 	bot             *tgbotapi.BotAPI
 	container       *dialogs.Container
+	access          app.AccessControl
 	userDialogState *app.UserDialogState
 
 	updates          tgbotapi.UpdatesChannel
 	cancelDispatcher func()
+	// dispatcherDone is closed when the update loop is stopped.
+	dispatcherDone chan struct{}
+	// webhook is not nil when updates are received by webhook.
+	webhook *webhookState
+	// handlersWG waits for handlers of received messages.
+	handlersWG sync.WaitGroup
 
 	muLocker     sync.Mutex
 	lockByUserID map[int64]*sync.Mutex
+
+	connected atomic.Bool
+	// loopBeat is the time in unix nanoseconds when the update loop reported last time. It's zero while the loop isn't running.
+	loopBeat atomic.Int64
+
+	muHandlers    sync.Mutex
+	lastHandlerID uint64
+	handlerStarts map[uint64]time.Time
 }
 
-func NewMsgProcessor(apiKey string, debugMode bool, container *dialogs.Container) *MsgProcessor {
+func NewMsgProcessor(apiKey string, debugMode bool, container *dialogs.Container, access app.AccessControl) *MsgProcessor {
 	return &MsgProcessor{
 		apiKey:          apiKey,
 		debugMode:       debugMode,
 		container:       container,
+		access:          access,
 		userDialogState: app.NewUserDialogState(),
 		lockByUserID:    make(map[int64]*sync.Mutex),
+		handlerStarts:   make(map[uint64]time.Time),
 	}
 }
 
@@ -38,10 +58,14 @@ func (p *MsgProcessor) connect() (err error) {
 	if p.apiKey == "" {
 		return errors.New("bot api key is not specified")
 	}
-	p.bot, err = tgbotapi.NewBotAPI(p.apiKey)
+	p.bot, err = tgbotapi.NewBotAPIWithClient(p.apiKey, tgbotapi.APIEndpoint, apiClient{client: &http.Client{}})
 	if err != nil {
 		return fmt.Errorf("can't create bot api: %w", err)
 	}
 	p.bot.Debug = p.debugMode
+	if err := p.registerCommands(); err != nil {
+		return fmt.Errorf("failed to register commands: %w", err)
+	}
+	p.connected.Store(true)
 	return nil
 }
@@ -0,0 +1,75 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+// resumeQueues continues downloading of queues left after the previous run of the bot.
+func (p *MsgProcessor) resumeQueues() {
+	ctx := context.Background()
+	log := logging.FromContextS(ctx)
+	queue := p.container.DownloadQueue()
+	userIDs, err := queue.UserIDs(ctx)
+	if err != nil {
+		log.Errorf("Failed to get users with queued downloads: %v", err)
+		return
+	}
+	for _, userID := range userIDs {
+		if err := p.resumeQueue(ctx, queue, userID); err != nil {
+			log.Errorf("Failed to resume queue of user %d: %v", userID, err)
+		}
+	}
+	if len(userIDs) > 0 {
+		log.Infof("Queues of %d users are resumed", len(userIDs))
+	}
+}
+
+func (p *MsgProcessor) resumeQueue(ctx context.Context, queue app.DownloadQueue, userID int64) error {
+	mu := p.userLock(userID)
+	mu.Lock()
+	defer mu.Unlock()
+	queued, err := queue.List(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list queue: %w", err)
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+	ctx = logging.NewContextS(ctx,
+		"request_id", genRequestID(),
+		"user_tg_id", userID,
+		"user_name", queued[0].UserName,
+	)
+	log := logging.FromContextS(ctx)
+	var allowed []app.QueuedDownload
+	for _, item := range queued {
+		if err := p.access.CheckAccess(userID, jobChatID(item)); err != nil {
+			log.Infof("Queued download %q is dropped, user has no access anymore: %v", item.Title, err)
+			if _, err := queue.Remove(ctx, userID, item.ID); err != nil {
+				return fmt.Errorf("failed to remove download of denied user: %w", err)
+			}
+			p.reportDeniedJob(ctx, item)
+			continue
+		}
+		allowed = append(allowed, item)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	from := &tgbotapi.User{ID: userID, UserName: allowed[0].UserName}
+	rup := NewReqUserProvider(p.bot, from, jobChatID(allowed[0]), p.userDialogState, p.container)
+	dlg, err := rup.RedirectToDialog(ctx, app.DialogYoutubeDownload)
+	if err != nil {
+		return fmt.Errorf("failed to redirect to download dialog: %w", err)
+	}
+	downloadDlg, ok := dlg.(app.DownloadDialog)
+	if !ok {
+		return fmt.Errorf("dialog %T can't resume queue", dlg)
+	}
+	defer p.saveDialog(ctx, from)
+	return downloadDlg.ResumeQueue(ctx)
+}
@@ -0,0 +1,141 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+const oneMB = 1 << 20
+
+// quotaLimit is the limit which can be changed by /setquota command.
+type quotaLimit struct {
+	name string
+	set  func(l *app.QuotaLimits, v int64)
+}
+
+var quotaLimits = []quotaLimit{
+	{
+		name: "downloads_day",
+		set:  func(l *app.QuotaLimits, v int64) { l.DailyDownloads = int(v) },
+	},
+	{
+		name: "downloads_month",
+		set:  func(l *app.QuotaLimits, v int64) { l.MonthlyDownloads = int(v) },
+	},
+	{
+		name: "mb_day",
+		set:  func(l *app.QuotaLimits, v int64) { l.DailyMB = v },
+	},
+	{
+		name: "mb_month",
+		set:  func(l *app.QuotaLimits, v int64) { l.MonthlyMB = v },
+	},
+	{
+		name: "minutes_day",
+		set:  func(l *app.QuotaLimits, v int64) { l.DailyAudioMinutes = int(v) },
+	},
+	{
+		name: "minutes_month",
+		set:  func(l *app.QuotaLimits, v int64) { l.MonthlyAudioMinutes = int(v) },
+	},
+}
+
+// onQuota shows limits of user and their usage. Admin can see limits of another user by id.
+func (p *MsgProcessor) onQuota(ctx context.Context, rup app.ReqUserProvider, _ app.Dialog, args string) error {
+	userID := rup.User().ID
+	if strings.TrimSpace(args) != "" && p.access.IsAdmin(userID) {
+		var err error
+		userID, err = parseUserID("quota", args)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := app.SendMessagef(ctx, rup, "%s", formatQuota(p.container.Quota().Status(userID)))
+	return err
+}
+
+// onSetQuota overrides limits of user, e.g. "/setquota 123 downloads_day=10 mb_month=0".
+// Zero value removes the limit, "default" resets all limits of user to default ones.
+func (p *MsgProcessor) onSetQuota(ctx context.Context, rup app.ReqUserProvider, _ app.Dialog, args string) error {
+	fields := strings.Fields(args)
+	names := make([]string, 0, len(quotaLimits))
+	for _, l := range quotaLimits {
+		names = append(names, l.name)
+	}
+	usageErr := app.NewUserError(fmt.Sprintf("Укажите идентификатор пользователя и новые лимиты, например <code>/setquota 123456789 downloads_day=10 mb_month=0</code>. Значение 0 снимает ограничение, <code>default</code> возвращает лимиты по умолчанию.\n\nЛимиты: %s", strings.Join(names, ", ")))
+	if len(fields) < 2 {
+		return usageErr
+	}
+	userID, err := parseUserID("setquota", fields[0])
+	if err != nil {
+		return err
+	}
+	quota := p.container.Quota()
+	var limits *app.QuotaLimits
+	if len(fields) != 2 || fields[1] != "default" {
+		newLimits := quota.Status(userID).Limits
+		for _, field := range fields[1:] {
+			if err := setQuotaLimit(&newLimits, field); err != nil {
+				return usageErr.WithCause(err)
+			}
+		}
+		limits = &newLimits
+	}
+	if err := quota.SetLimits(ctx, userID, limits); err != nil {
+		return err
+	}
+	logging.FromContextS(ctx).Infof("Quota limits of user %d are changed to %+v", userID, limits)
+	_, err = app.SendMessagef(ctx, rup, "Лимиты пользователя <code>%d</code> изменены.\n\n%s", userID, formatQuota(quota.Status(userID)))
+	return err
+}
+
+// setQuotaLimit sets the limit from the argument like "downloads_day=10".
+func setQuotaLimit(limits *app.QuotaLimits, arg string) error {
+	name, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return fmt.Errorf("argument %q isn't a pair of name and value", arg)
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid value of limit %q: %q", name, value)
+	}
+	for _, l := range quotaLimits {
+		if l.name == name {
+			l.set(limits, v)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown limit %q", name)
+}
+
+func formatQuota(status app.QuotaStatus) string {
+	l, daily, monthly := status.Limits, status.Daily, status.Monthly
+	text := &strings.Builder{}
+	text.WriteString("<b>Лимиты загрузок</b>")
+	if status.Overridden {
+		text.WriteString(" (установлены администратором)")
+	}
+	text.WriteString("\n\n<b>Сегодня:</b>\n")
+	writeQuotaUsage(text, daily, int64(l.DailyDownloads), l.DailyMB, int64(l.DailyAudioMinutes))
+	text.WriteString("\n<b>В этом месяце:</b>\n")
+	writeQuotaUsage(text, monthly, int64(l.MonthlyDownloads), l.MonthlyMB, int64(l.MonthlyAudioMinutes))
+	return text.String()
+}
+
+func writeQuotaUsage(text *strings.Builder, usage app.QuotaUsage, downloads, mb, minutes int64) {
+	_, _ = fmt.Fprintf(text, "Загрузки: %d из %s\n", usage.Downloads, formatLimit(downloads))
+	_, _ = fmt.Fprintf(text, "Объем: %.1f MB из %s\n", float64(usage.Bytes)/oneMB, formatLimit(mb))
+	_, _ = fmt.Fprintf(text, "Аудио: %d мин. из %s\n", int(usage.AudioDuration.Minutes()), formatLimit(minutes))
+}
+
+func formatLimit(limit int64) string {
+	if limit == 0 {
+		return "∞"
+	}
+	return strconv.FormatInt(limit, 10)
+}
@@ -2,6 +2,7 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/vm-affekt/tgytbot/internal/app"
@@ -9,6 +10,7 @@ import (
 	"github.com/vm-affekt/tgytbot/internal/logging"
 	"io"
 	"strings"
+	"time"
 )
 
 type reqUserProvider struct {
@@ -16,17 +18,20 @@ type reqUserProvider struct {
 	userDialogState *app.UserDialogState
 	container       *dialogs.Container
 	from            *tgbotapi.User
+	chatID          int64
 }
 
 func NewReqUserProvider(
 	bot *tgbotapi.BotAPI,
 	from *tgbotapi.User,
+	chatID int64,
 	userDialogState *app.UserDialogState,
 	container *dialogs.Container,
 ) *reqUserProvider {
 	return &reqUserProvider{
 		bot:             bot,
 		from:            from,
+		chatID:          chatID,
 		userDialogState: userDialogState,
 		container:       container,
 	}
@@ -36,6 +41,10 @@ func (rup *reqUserProvider) User() *tgbotapi.User {
 	return rup.from
 }
 
+func (rup *reqUserProvider) ChatID() int64 {
+	return rup.chatID
+}
+
 func (rup *reqUserProvider) SendMessageWithKeyboardf(ctx context.Context, replyKeyboard *tgbotapi.ReplyKeyboardMarkup, text string, args ...interface{}) (int, error) {
 	msg := rup.makeTextMsgf(text, args...)
 	if replyKeyboard != nil {
@@ -47,7 +56,38 @@ func (rup *reqUserProvider) SendMessageWithKeyboardf(ctx context.Context, replyK
 	return rup.sendMessage(ctx, msg)
 }
 
-func (rup *reqUserProvider) SendAudio(ctx context.Context, stream io.Reader, fileName string) error {
+func (rup *reqUserProvider) SendMessageWithInlineKeyboardf(ctx context.Context, inlineKeyboard *tgbotapi.InlineKeyboardMarkup, text string, args ...interface{}) (int, error) {
+	msg := rup.makeTextMsgf(text, args...)
+	if inlineKeyboard != nil {
+		msg.ReplyMarkup = inlineKeyboard
+	}
+	return rup.sendMessage(ctx, msg)
+}
+
+func (rup *reqUserProvider) EditMessagef(ctx context.Context, msgID int, inlineKeyboard *tgbotapi.InlineKeyboardMarkup, text string, args ...interface{}) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("context is done while editing message: %w", ctx.Err())
+	default:
+	}
+	edit := tgbotapi.NewEditMessageText(rup.from.ID, msgID, fmt.Sprintf(text, args...))
+	edit.ParseMode = "HTML"
+	edit.ReplyMarkup = inlineKeyboard
+	if _, err := rup.bot.Send(edit); err != nil {
+		// Telegram rejects edits which don't change the message, it's not an error for us.
+		if strings.Contains(err.Error(), "message is not modified") {
+			return nil
+		}
+		var tgErr *tgbotapi.Error
+		if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+			err = &app.RateLimitError{RetryAfter: time.Duration(tgErr.RetryAfter) * time.Second}
+		}
+		return fmt.Errorf("failed to edit message with id %v: %w", msgID, err)
+	}
+	return nil
+}
+
+func (rup *reqUserProvider) SendAudio(ctx context.Context, stream io.Reader, fileName string, meta app.MediaMeta) (string, error) {
 	log := logging.FromContextS(ctx)
 	log.Infof("Uploading audio file %q to Telegram...", fileName)
 	file := tgbotapi.FileReader{
@@ -55,13 +95,70 @@ func (rup *reqUserProvider) SendAudio(ctx context.Context, stream io.Reader, fil
 		Reader: stream,
 	}
 	audioMsg := tgbotapi.NewAudio(rup.from.ID, file)
-	if _, err := rup.bot.Send(audioMsg); err != nil {
-		return fmt.Errorf("failed to upload audio to telegram: %w", err)
+	audioMsg.Title = meta.Title
+	audioMsg.Performer = meta.Artist
+	audioMsg.Duration = int(meta.Duration.Seconds())
+	if len(meta.Thumbnail) > 0 {
+		audioMsg.Thumb = tgbotapi.FileBytes{
+			Name:  "thumb.jpg",
+			Bytes: meta.Thumbnail,
+		}
+	}
+	sentMsg, err := rup.bot.Send(audioMsg)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload audio to telegram: %w", err)
 	}
 	log.Info("Uploading audio file to Telegram successfully done!")
+	return sentFileID(sentMsg), nil
+}
+
+func (rup *reqUserProvider) SendVideo(ctx context.Context, stream io.Reader, fileName string) (string, error) {
+	log := logging.FromContextS(ctx)
+	log.Infof("Uploading video file %q to Telegram...", fileName)
+	file := tgbotapi.FileReader{
+		Name:   fileName,
+		Reader: stream,
+	}
+	videoMsg := tgbotapi.NewVideo(rup.from.ID, file)
+	videoMsg.SupportsStreaming = true
+	sentMsg, err := rup.bot.Send(videoMsg)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload video to telegram: %w", err)
+	}
+	log.Info("Uploading video file to Telegram successfully done!")
+	return sentFileID(sentMsg), nil
+}
+
+func (rup *reqUserProvider) SendMediaByFileID(ctx context.Context, kind app.MediaKind, fileID string) error {
+	logging.FromContextS(ctx).Infof("Sending file %q uploaded before...", fileID)
+	var msg tgbotapi.Chattable
+	if kind == app.MediaVideo {
+		videoMsg := tgbotapi.NewVideo(rup.from.ID, tgbotapi.FileID(fileID))
+		videoMsg.SupportsStreaming = true
+		msg = videoMsg
+	} else {
+		msg = tgbotapi.NewAudio(rup.from.ID, tgbotapi.FileID(fileID))
+	}
+	if _, err := rup.bot.Send(msg); err != nil {
+		return fmt.Errorf("failed to send file by file_id: %w", err)
+	}
 	return nil
 }
 
+// sentFileID returns file_id of the media in the sent message. Telegram may send the file as document
+// if it can't be played as audio or video.
+func sentFileID(msg tgbotapi.Message) string {
+	switch {
+	case msg.Audio != nil:
+		return msg.Audio.FileID
+	case msg.Video != nil:
+		return msg.Video.FileID
+	case msg.Document != nil:
+		return msg.Document.FileID
+	}
+	return ""
+}
+
 func (rup *reqUserProvider) RedirectToDialog(ctx context.Context, id app.DialogID) (newDlg app.Dialog, err error) {
 	log := logging.FromContextS(ctx)
 	log.Infof("Redirecting to dialog with id=%d...", id)
@@ -70,7 +167,7 @@ func (rup *reqUserProvider) RedirectToDialog(ctx context.Context, id app.DialogI
 	}
 
 	newDlg = rup.container.CreateDialog(id, rup)
-	rup.userDialogState.SetDialogForUser(rup.from.ID, newDlg)
+	rup.userDialogState.SetDialogForUser(rup.from.ID, id, newDlg)
 	if err := newDlg.OnEnter(ctx); err != nil {
 		return nil, fmt.Errorf("failed OnEnter on new dialog: %w", err)
 	}
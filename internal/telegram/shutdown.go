@@ -0,0 +1,66 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+// interruptTimeout is the time given to interrupted downloads to notify users and put jobs back to the queue.
+const interruptTimeout = 15 * time.Second
+
+// Shutdown stops receiving of updates and waits for running downloads until ctx is done.
+// Downloads which aren't finished in time are interrupted: users are notified and jobs are put back
+// to the queue to be continued after restart.
+func (p *MsgProcessor) Shutdown(ctx context.Context) error {
+	log := logging.FromContextS(ctx)
+	log.Info("Stopping receiving of updates...")
+	// The bot isn't ready anymore, so new traffic isn't routed to it.
+	p.connected.Store(false)
+	if p.webhook != nil {
+		if err := p.stopWebhook(ctx); err != nil {
+			log.Errorf("Failed to stop webhook: %v", err)
+		}
+	} else if p.bot != nil {
+		p.bot.StopReceivingUpdates()
+	}
+	// handlersWG.Add is called by the dispatcher, so handlers can be waited only after it's stopped.
+	dispatcherStopped := true
+	if p.cancelDispatcher != nil {
+		// The dispatcher handles updates left in the channel before it stops. They aren't sent by Telegram again.
+		p.cancelDispatcher()
+		select {
+		case <-p.dispatcherDone:
+		case <-ctx.Done():
+			dispatcherStopped = false
+			log.Warn("Dispatcher isn't stopped in time")
+		}
+	}
+
+	if dispatcherStopped {
+		log.Info("Waiting for handlers of received messages...")
+		handlersDone := make(chan struct{})
+		go func() {
+			p.handlersWG.Wait()
+			close(handlersDone)
+		}()
+		select {
+		case <-handlersDone:
+		case <-ctx.Done():
+			log.Warn("Handlers of received messages aren't finished in time")
+		}
+	}
+
+	jobs := p.container.Jobs()
+	log.Info("Waiting for running downloads...")
+	interrupted, err := jobs.Shutdown(ctx, interruptTimeout)
+	if interrupted > 0 {
+		log.Warnf("%d downloads are interrupted", interrupted)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to wait for interrupted downloads: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/app"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+// saveDialog saves the current dialog of user to storage, so it can be restored after restart.
+func (p *MsgProcessor) saveDialog(ctx context.Context, from *tgbotapi.User) {
+	log := logging.FromContextS(ctx)
+	id, ok := p.userDialogState.FindDialogIDByUser(from.ID)
+	if !ok {
+		return
+	}
+	stored := app.StoredDialog{
+		UserID:   from.ID,
+		UserName: from.UserName,
+		ID:       id,
+	}
+	if dlg, ok := p.userDialogState.FindDialogByUser(from.ID).(app.StatefulDialog); ok {
+		state, err := dlg.MarshalState()
+		if err != nil {
+			log.Errorf("Failed to marshal state of dialog %d: %v", id, err)
+			return
+		}
+		stored.State = state
+	}
+	if err := p.container.Storage().SaveDialog(ctx, stored); err != nil {
+		log.Errorf("Failed to save dialog %d: %v", id, err)
+	}
+}
+
+// restoreDialogs restores dialogs of users saved before the restart of bot.
+func (p *MsgProcessor) restoreDialogs() {
+	ctx := context.Background()
+	log := logging.FromContextS(ctx)
+	dialogs, err := p.container.Storage().Dialogs(ctx)
+	if err != nil {
+		log.Errorf("Failed to load dialogs from storage: %v", err)
+		return
+	}
+	var restored int
+	for _, stored := range dialogs {
+		if err := p.restoreDialog(ctx, stored); err != nil {
+			log.Errorf("Failed to restore dialog %d of user %d: %v", stored.ID, stored.UserID, err)
+			continue
+		}
+		restored++
+	}
+	if restored > 0 {
+		log.Infof("Dialogs of %d users are restored", restored)
+	}
+}
+
+func (p *MsgProcessor) restoreDialog(ctx context.Context, stored app.StoredDialog) error {
+	id := stored.ID
+	if err := id.Validate(); err != nil {
+		return err
+	}
+	// Download can't be continued in the new dialog. Interrupted downloads are recovered
+	// from the queue, and the download dialog is entered again by resuming of the queue.
+	if id == app.DialogYoutubeDownload {
+		id = app.DialogMain
+	}
+	// Only private chats are supported, so id of the chat is the same as id of the user.
+	from := &tgbotapi.User{ID: stored.UserID, UserName: stored.UserName}
+	rup := NewReqUserProvider(p.bot, from, from.ID, p.userDialogState, p.container)
+	dlg := p.container.CreateDialog(id, rup)
+	if statefulDlg, ok := dlg.(app.StatefulDialog); ok && len(stored.State) > 0 && id == stored.ID {
+		if err := statefulDlg.RestoreState(stored.State); err != nil {
+			return fmt.Errorf("failed to restore state: %w", err)
+		}
+	}
+	p.userDialogState.SetDialogForUser(stored.UserID, id, dlg)
+	return nil
+}
+
+// recoverActiveJobs puts downloads which were running when bot crashed back to the beginning of queues.
+// They are continued by resuming of queues.
+func (p *MsgProcessor) recoverActiveJobs() {
+	ctx := context.Background()
+	log := logging.FromContextS(ctx)
+	storage := p.container.Storage()
+	jobs, err := storage.ActiveJobs(ctx)
+	if err != nil {
+		log.Errorf("Failed to load active jobs from storage: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		log := log.With("user_tg_id", job.UserID)
+		if err := p.access.CheckAccess(job.UserID, jobChatID(job)); err != nil {
+			log.Infof("Download %q interrupted by crash is dropped, user has no access anymore: %v", job.Title, err)
+			p.reportDeniedJob(ctx, job)
+		} else if err := p.container.DownloadQueue().PushFront(ctx, job); err != nil {
+			log.Errorf("Failed to put interrupted download %q back to queue: %v", job.Title, err)
+			p.reportInterruptedJob(ctx, job)
+		} else {
+			log.Infof("Download %q interrupted by crash is put back to queue", job.Title)
+		}
+		if err := storage.DeleteActiveJob(ctx, job.UserID); err != nil {
+			log.Errorf("Failed to delete active job from storage: %v", err)
+		}
+	}
+}
+
+// reportInterruptedJob notifies user about the download which can't be continued.
+func (p *MsgProcessor) reportInterruptedJob(ctx context.Context, job app.QueuedDownload) {
+	_, _ = app.SendMessagef(ctx, p.jobUserProvider(job), "Бот был перезапущен после сбоя. Загрузка <b>%s</b> прервана, отправьте ссылку еще раз.", html.EscapeString(job.Title))
+}
+
+// reportDeniedJob notifies user about the download which is dropped because user has no access anymore.
+func (p *MsgProcessor) reportDeniedJob(ctx context.Context, job app.QueuedDownload) {
+	_, _ = app.SendMessagef(ctx, p.jobUserProvider(job), "Загрузка <b>%s</b> отменена: у вас больше нет доступа к боту.", html.EscapeString(job.Title))
+}
+
+// jobUserProvider returns the provider sending messages to the chat where the download is requested.
+func (p *MsgProcessor) jobUserProvider(job app.QueuedDownload) app.ReqUserProvider {
+	from := &tgbotapi.User{ID: job.UserID, UserName: job.UserName}
+	return NewReqUserProvider(p.bot, from, jobChatID(job), p.userDialogState, p.container)
+}
+
+// jobChatID returns the chat where the download is requested. Downloads queued by older versions
+// have no chat, they could be requested only in private chats, where id of the chat is the same as id of the user.
+func jobChatID(job app.QueuedDownload) int64 {
+	if job.ChatID != 0 {
+		return job.ChatID
+	}
+	return job.UserID
+}
@@ -0,0 +1,186 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vm-affekt/tgytbot/internal/logging"
+)
+
+const (
+	DefaultWebhookPath       = "/telegram/webhook"
+	DefaultWebhookListenAddr = ":8443"
+
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// secretTokenRegexp matches tokens allowed by Telegram.
+var secretTokenRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// WebhookConfig configures receiving of updates by webhook.
+type WebhookConfig struct {
+	// URL is the public base URL of the bot, e.g. "https://bot.example.com". Path is appended to it.
+	URL string
+	// Path is the path of HTTP handler receiving updates.
+	Path string
+	// ListenAddr is the address of built-in HTTP listener.
+	ListenAddr string
+	// SecretToken is sent by Telegram in every request. Requests with another token are rejected.
+	// Empty token disables the check.
+	SecretToken string
+	// TLSCertFile and TLSKeyFile enable HTTPS on the listener. Plain HTTP is used if they are empty,
+	// e.g. behind reverse proxy terminating TLS.
+	TLSCertFile string
+	TLSKeyFile  string
+	// DeleteOnShutdown asks to delete the webhook when the bot stops. Keep the webhook when the bot
+	// is started on demand by incoming requests.
+	DeleteOnShutdown bool
+}
+
+// Validate checks the config without applying defaults.
+func (cfg WebhookConfig) Validate() error {
+	if cfg.URL == "" {
+		return errors.New("webhook url is not specified")
+	}
+	if cfg.SecretToken != "" && !secretTokenRegexp.MatchString(cfg.SecretToken) {
+		return errors.New("secret token must contain 1-256 characters A-Z, a-z, 0-9, _ and -")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return errors.New("both TLS certificate and key files must be specified")
+	}
+	return nil
+}
+
+// StartWebhook starts HTTP listener receiving updates and registers it in Telegram by setWebhook.
+func (p *MsgProcessor) StartWebhook(cfg WebhookConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Path == "" {
+		cfg.Path = DefaultWebhookPath
+	}
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = DefaultWebhookListenAddr
+	}
+	if err := p.connect(); err != nil {
+		return fmt.Errorf("failed to connect Telegram server: %w", err)
+	}
+	log := logging.FromContextS(context.Background())
+
+	updates := make(chan tgbotapi.Update, p.bot.Buffer)
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, p.webhookHandler(cfg.SecretToken, updates))
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	// Listener is created in advance to report errors like busy port to the caller.
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen %q: %w", cfg.ListenAddr, err)
+	}
+	go func() {
+		var err error
+		if cfg.TLSCertFile != "" {
+			err = srv.ServeTLS(listener, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = srv.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Webhook listener is stopped with error: %v", err)
+		}
+	}()
+	log.Infof("Webhook listener started on %q", cfg.ListenAddr)
+
+	webhookURL := strings.TrimSuffix(cfg.URL, "/") + cfg.Path
+	if err := p.setWebhook(webhookURL, cfg.SecretToken); err != nil {
+		_ = srv.Close()
+		return err
+	}
+	log.Infof("Webhook is set to %q", webhookURL)
+
+	p.webhook = &webhookState{
+		srv:              srv,
+		updates:          updates,
+		deleteOnShutdown: cfg.DeleteOnShutdown,
+	}
+	p.updates = updates
+	p.restoreDialogs()
+	p.recoverActiveJobs()
+	p.startDispatcher()
+	p.resumeQueues()
+	return nil
+}
+
+// stopWebhook stops HTTP listener, closes the channel of updates and deletes the webhook if it's configured.
+// The error is returned only if the listener isn't stopped, the channel stays open then.
+func (p *MsgProcessor) stopWebhook(ctx context.Context) error {
+	if p.webhook == nil {
+		return nil
+	}
+	log := logging.FromContextS(ctx)
+	// Shutdown waits for running handlers, so nobody writes to updates after that.
+	if err := p.webhook.srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shutdown webhook listener: %w", err)
+	}
+	close(p.webhook.updates)
+	if p.webhook.deleteOnShutdown {
+		if _, err := p.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			log.Errorf("Failed to delete webhook: %v", err)
+		} else {
+			log.Info("Webhook is deleted")
+		}
+	}
+	p.webhook = nil
+	return nil
+}
+
+type webhookState struct {
+	srv              *http.Server
+	updates          chan tgbotapi.Update
+	deleteOnShutdown bool
+}
+
+func (p *MsgProcessor) webhookHandler(secretToken string, updates chan<- tgbotapi.Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := logging.FromContextS(r.Context())
+		if secretToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(secretToken)) != 1 {
+			log.Warnf("Rejected webhook request from %q with wrong secret token", r.RemoteAddr)
+			http.Error(w, "wrong secret token", http.StatusUnauthorized)
+			return
+		}
+		upd, err := p.bot.HandleUpdate(r)
+		if err != nil {
+			log.Warnf("Failed to parse webhook update: %v", err)
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+		select {
+		case updates <- *upd:
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+			// Telegram will retry the update later.
+			http.Error(w, "update isn't accepted", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// setWebhook calls setWebhook method directly, because WebhookConfig of the library doesn't support secret token.
+func (p *MsgProcessor) setWebhook(webhookURL, secretToken string) error {
+	params := make(tgbotapi.Params)
+	params["url"] = webhookURL
+	params.AddNonEmpty("secret_token", secretToken)
+	if _, err := p.bot.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+	return nil
+}